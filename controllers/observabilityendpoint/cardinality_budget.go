@@ -0,0 +1,449 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/stolostron/endpoint-metrics-operator/pkg/util"
+)
+
+const (
+	cardinalityStatusName    = "metrics-cardinality-status"
+	cardinalityStatusKey     = "status.yaml"
+	defaultCardinalityTopN   = 10
+	defaultCardinalityPeriod = 10 * time.Minute
+	// the number of the names counted in one query
+	seriesQueryBatchSize = 100
+	seriesQueryTimeout   = 30 * time.Second
+	// the failed count is retried after the period instead of in every reconcile
+	seriesCountRetryPeriod = time.Minute
+)
+
+// seriesCount is the number of the series of an allowlist entry in the metrics source
+type seriesCount struct {
+	// Entry is the metric name in names or the matcher in matches
+	Entry string `yaml:"entry"`
+	// Type is names or matches
+	Type string `yaml:"type"`
+	// UserWorkload is true for the entry in the userWorkload section of the allowlist
+	UserWorkload bool `yaml:"userWorkload,omitempty"`
+	Series       int  `yaml:"series"`
+}
+
+// key identifies the allowlist entry of the count
+func (c seriesCount) key() string {
+	return fmt.Sprintf("%t/%s/%s", c.UserWorkload, c.Type, c.Entry)
+}
+
+// cardinalityStatus is the content of the status configmap published for the cardinality budget
+type cardinalityStatus struct {
+	MaxSeries       int           `yaml:"maxSeries"`
+	TotalSeries     int           `yaml:"totalSeries"`
+	CollectedSeries int           `yaml:"collectedSeries"`
+	LastCounted     string        `yaml:"lastCounted"`
+	TopEntries      []seriesCount `yaml:"topEntries"`
+	DroppedEntries  []seriesCount `yaml:"droppedEntries,omitempty"`
+}
+
+// seriesCountCache keeps the last counts, so that the metrics sources are not queried in every reconcile
+type seriesCountCache struct {
+	// key identifies the metrics sources and the allowlist entries the counts are for
+	key       string
+	countedAt time.Time
+	counts    []seriesCount
+	// err is the error of the last count, which is returned until the count is retried
+	err error
+}
+
+// seriesQuerier runs the instant query against the metrics source
+type seriesQuerier interface {
+	query(ctx context.Context, q string) (model.Vector, error)
+}
+
+// promClient queries the Prometheus or Thanos Querier http api
+type promClient struct {
+	serverURL string
+	token     string
+	client    *http.Client
+}
+
+// newPromClient creates the client for the metrics source with the same CA and token as the metrics collector
+func newPromClient(ctx context.Context, c client.Client, source MetricsSource) (*promClient, error) {
+	caRef := KeyRef{Name: caConfigmapName, Key: "service-ca.crt"}
+	if source.CAConfigMap != nil {
+		caRef = *source.CAConfigMap
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: caRef.Name, Namespace: namespace}, cm)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get the CA configmap of the metrics source", "name", caRef.Name)
+		return nil, err
+	}
+	if err == nil && cm.Data[caRef.Key] != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cm.Data[caRef.Key])) {
+			return nil, fmt.Errorf("no valid CA certificate in configmap %s", caRef.Name)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	token := ""
+	if source.TokenSecret != nil {
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, types.NamespacedName{Name: source.TokenSecret.Name, Namespace: namespace}, secret)
+		if err != nil {
+			log.Error(err, "Failed to get the token secret of the metrics source", "name", source.TokenSecret.Name)
+			return nil, err
+		}
+		token = string(secret.Data[source.TokenSecret.Key])
+	} else if data, err := ioutil.ReadFile(saTokenFile); err == nil {
+		// the operator runs with the same service account as the metrics collector
+		token = string(data)
+	}
+
+	return &promClient{
+		serverURL: strings.TrimSuffix(source.ServerURL, "/"),
+		token:     strings.TrimSpace(token),
+		client: &http.Client{
+			Timeout:   seriesQueryTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+func (p *promClient) query(ctx context.Context, q string) (model.Vector, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.serverURL+"/api/v1/query",
+		strings.NewReader(url.Values{"query": {q}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string       `json:"resultType"`
+			Result     model.Vector `json:"result"`
+		} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode the response of %s with status code %d: %v",
+			p.serverURL, resp.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("query %q failed with status code %d: %s", q, resp.StatusCode, result.Error)
+	}
+	if result.Data.ResultType != model.ValVector.String() {
+		return nil, fmt.Errorf("unexpected result type %s of query %q", result.Data.ResultType, q)
+	}
+	return result.Data.Result, nil
+}
+
+// countAllowlistSeries counts the series of the names and matches in the allowlist,
+// the counts are returned in the priority order of the entries
+func countAllowlistSeries(ctx context.Context, q seriesQuerier, allowlist MetricsAllowlist) ([]seriesCount, error) {
+	nameCounts := map[string]int{}
+	for i := 0; i < len(allowlist.NameList); i += seriesQueryBatchSize {
+		end := i + seriesQueryBatchSize
+		if end > len(allowlist.NameList) {
+			end = len(allowlist.NameList)
+		}
+		vector, err := q.query(ctx, fmt.Sprintf("count by (__name__) ({__name__=~%q})",
+			strings.Join(allowlist.NameList[i:end], "|")))
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range vector {
+			nameCounts[string(sample.Metric[model.MetricNameLabel])] = int(sample.Value)
+		}
+	}
+
+	counts := []seriesCount{}
+	for _, name := range allowlist.NameList {
+		counts = append(counts, seriesCount{Entry: name, Type: "names", Series: nameCounts[name]})
+	}
+	for _, match := range allowlist.MatchList {
+		vector, err := q.query(ctx, fmt.Sprintf("count({%s})", match))
+		if err != nil {
+			return nil, err
+		}
+		series := 0
+		if len(vector) > 0 {
+			series = int(vector[0].Value)
+		}
+		counts = append(counts, seriesCount{Entry: match, Type: "matches", Series: series})
+	}
+	return counts, nil
+}
+
+// applySeriesBudget drops the lowest-priority entries with series from the allowlist and its userWorkload section
+// until the total series are within maxSeries. The priority follows the order of the counts. It returns
+// the allowlist to deploy and the dropped entries.
+func applySeriesBudget(allowlist MetricsAllowlist, counts []seriesCount, maxSeries int) (MetricsAllowlist,
+	[]seriesCount) {
+	total := 0
+	for _, c := range counts {
+		total += c.Series
+	}
+	if maxSeries <= 0 || total <= maxSeries {
+		return allowlist, nil
+	}
+
+	dropped := []seriesCount{}
+	droppedEntries := map[string]bool{}
+	for i := len(counts) - 1; i >= 0 && total > maxSeries; i-- {
+		if counts[i].Series == 0 {
+			continue
+		}
+		total -= counts[i].Series
+		dropped = append(dropped, counts[i])
+		droppedEntries[counts[i].key()] = true
+	}
+
+	result := allowlist
+	result.NameList = keptEntries(allowlist.NameList, "names", false, droppedEntries)
+	result.MatchList = keptEntries(allowlist.MatchList, "matches", false, droppedEntries)
+	if allowlist.UserWorkload != nil {
+		uwlAllowlist := *allowlist.UserWorkload
+		uwlAllowlist.NameList = keptEntries(uwlAllowlist.NameList, "names", true, droppedEntries)
+		uwlAllowlist.MatchList = keptEntries(uwlAllowlist.MatchList, "matches", true, droppedEntries)
+		result.UserWorkload = &uwlAllowlist
+	}
+	return result, dropped
+}
+
+// keptEntries returns the entries of the type which are not dropped
+func keptEntries(entries []string, entryType string, userWorkload bool, droppedEntries map[string]bool) []string {
+	kept := []string{}
+	for _, entry := range entries {
+		if !droppedEntries[seriesCount{Entry: entry, Type: entryType, UserWorkload: userWorkload}.key()] {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// topSeriesCounts returns the n entries with the most series
+func topSeriesCounts(counts []seriesCount, n int) []seriesCount {
+	top := make([]seriesCount, len(counts))
+	copy(top, counts)
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].Series > top[j].Series
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// formatSeriesCounts formats the counts for the condition message
+func formatSeriesCounts(counts []seriesCount) string {
+	entries := []string{}
+	for _, c := range counts {
+		entry := c.Entry
+		if c.Type == "matches" {
+			entry = "{" + entry + "}"
+		}
+		if c.UserWorkload {
+			entry = "userWorkload/" + entry
+		}
+		entries = append(entries, fmt.Sprintf("%s: %d", entry, c.Series))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// applyCardinalityBudget counts the series of the allowlist entries if the cardinality budget is enabled,
+// and returns the allowlist with the entries dropped for the budget and the status to report.
+// The userWorkload section is counted against the user workload metrics source if userWorkload is true,
+// and its entries have lower priority than the platform ones.
+// The allowlist is returned as it is if the series cannot be counted.
+func (r *ObservabilityAddonReconciler) applyCardinalityBudget(ctx context.Context, config EndpointConfig,
+	allowlist MetricsAllowlist, userWorkload bool) (MetricsAllowlist, util.Status) {
+	budget := config.CardinalityBudget
+	if !budget.Enabled {
+		if err := deleteCardinalityStatus(ctx, r.Client); err != nil {
+			log.Error(err, "Failed to delete the cardinality status configmap")
+		}
+		return allowlist, util.Status{Type: "WithinCardinalityBudget"}
+	}
+
+	counts, err := r.getSeriesCounts(ctx, config, allowlist, userWorkload)
+	if err != nil {
+		return allowlist, util.Status{Type: "CardinalityUnknown", Details: err.Error()}
+	}
+
+	topN := budget.TopN
+	if topN == 0 {
+		topN = defaultCardinalityTopN
+	}
+	result, dropped := applySeriesBudget(allowlist, counts, budget.MaxSeries)
+	status := cardinalityStatus{
+		MaxSeries:      budget.MaxSeries,
+		LastCounted:    r.seriesCounts.countedAt.UTC().Format(time.RFC3339),
+		TopEntries:     topSeriesCounts(counts, topN),
+		DroppedEntries: dropped,
+	}
+	for _, c := range counts {
+		status.TotalSeries += c.Series
+	}
+	status.CollectedSeries = status.TotalSeries
+	for _, c := range dropped {
+		status.CollectedSeries -= c.Series
+	}
+	util.RecordAllowlistSeries(status.TotalSeries, len(dropped))
+	if err := updateCardinalityStatus(ctx, r.Client, status); err != nil {
+		log.Error(err, "Failed to update the cardinality status configmap")
+	}
+
+	details := fmt.Sprintf("%d series, top entries: %s", status.TotalSeries, formatSeriesCounts(status.TopEntries))
+	if len(dropped) == 0 {
+		return result, util.Status{Type: "WithinCardinalityBudget", Details: details}
+	}
+	log.Info("Dropped the allowlist entries for the cardinality budget", "maxSeries", budget.MaxSeries,
+		"totalSeries", status.TotalSeries, "dropped", formatSeriesCounts(dropped))
+	return result, util.Status{
+		Type: "CardinalityBudgetExceeded",
+		Details: fmt.Sprintf("%d series exceed the budget of %d, dropped %s; top entries: %s", status.TotalSeries,
+			budget.MaxSeries, formatSeriesCounts(dropped), formatSeriesCounts(status.TopEntries)),
+	}
+}
+
+// getSeriesCounts returns the cached counts if they are counted for the same metrics sources and allowlist entries
+// within the interval, otherwise the series are counted again. The failed count is cached too and retried after
+// seriesCountRetryPeriod, so that an unreachable metrics source doesn't block every reconcile.
+func (r *ObservabilityAddonReconciler) getSeriesCounts(ctx context.Context, config EndpointConfig,
+	allowlist MetricsAllowlist, userWorkload bool) ([]seriesCount, error) {
+	interval := defaultCardinalityPeriod
+	if config.CardinalityBudget.Interval != "" {
+		d, _ := model.ParseDuration(config.CardinalityBudget.Interval)
+		interval = time.Duration(d)
+	}
+	retryPeriod := seriesCountRetryPeriod
+	if retryPeriod > interval {
+		retryPeriod = interval
+	}
+	uwlSource := uwlMetricsSource(config)
+	countUWL := userWorkload && !allowlist.UserWorkload.isEmpty()
+	key := seriesCountKey(config.MetricsSource, allowlist)
+	if countUWL {
+		key += "\n" + seriesCountKey(uwlSource, *allowlist.UserWorkload)
+	}
+	if r.seriesCounts.key == key {
+		age := time.Since(r.seriesCounts.countedAt)
+		if r.seriesCounts.err == nil && age < interval {
+			return r.seriesCounts.counts, nil
+		}
+		if r.seriesCounts.err != nil && age < retryPeriod {
+			return nil, r.seriesCounts.err
+		}
+	}
+
+	counts, err := r.countSeries(ctx, config.MetricsSource, allowlist)
+	if err == nil && countUWL {
+		var uwlCounts []seriesCount
+		uwlCounts, err = r.countSeries(ctx, uwlSource, *allowlist.UserWorkload)
+		for _, c := range uwlCounts {
+			c.UserWorkload = true
+			counts = append(counts, c)
+		}
+	}
+	r.seriesCounts = seriesCountCache{key: key, countedAt: time.Now(), counts: counts, err: err}
+	if err != nil {
+		log.Error(err, "Failed to count the series of the allowlist entries")
+		recordWarning(ctx, eventSeriesCountFailed, "Failed to count the series of the allowlist entries: %v", err)
+		return nil, err
+	}
+	return counts, nil
+}
+
+// countSeries counts the series of the names and matches of the allowlist in the metrics source
+func (r *ObservabilityAddonReconciler) countSeries(ctx context.Context, source MetricsSource,
+	allowlist MetricsAllowlist) ([]seriesCount, error) {
+	q := r.seriesQuerier
+	if q == nil {
+		p, err := newPromClient(ctx, r.Client, source)
+		if err != nil {
+			return nil, err
+		}
+		q = p
+	}
+	return countAllowlistSeries(ctx, q, allowlist)
+}
+
+// seriesCountKey identifies the metrics source and the entries of the allowlist counted in it
+func seriesCountKey(source MetricsSource, allowlist MetricsAllowlist) string {
+	return source.ServerURL + "\n" + strings.Join(allowlist.NameList, "\n") + "\n" +
+		strings.Join(allowlist.MatchList, "\n")
+}
+
+// updateCardinalityStatus creates or updates the status configmap for the cardinality budget
+func updateCardinalityStatus(ctx context.Context, c client.Client, status cardinalityStatus) error {
+	data, err := yaml.Marshal(status)
+	if err != nil {
+		return err
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cardinalityStatusName,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		Data: map[string]string{cardinalityStatusKey: string(data)},
+	}
+	found := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: cardinalityStatusName, Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return c.Create(ctx, cm)
+		}
+		return err
+	}
+	if found.Data[cardinalityStatusKey] == cm.Data[cardinalityStatusKey] {
+		return nil
+	}
+	cm.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+	return c.Update(ctx, cm)
+}
+
+func deleteCardinalityStatus(ctx context.Context, c client.Client) error {
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: cardinalityStatusName, Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return c.Delete(ctx, found)
+}
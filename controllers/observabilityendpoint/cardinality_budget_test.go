@@ -0,0 +1,266 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeSeriesQuerier returns the counts of the names and matches in the queries
+type fakeSeriesQuerier struct {
+	names   map[string]int
+	matches map[string]int
+	queries int
+	err     error
+}
+
+func (q *fakeSeriesQuerier) query(ctx context.Context, query string) (model.Vector, error) {
+	q.queries++
+	if q.err != nil {
+		return nil, q.err
+	}
+	vector := model.Vector{}
+	if strings.HasPrefix(query, "count by (__name__)") {
+		for name, count := range q.names {
+			if strings.Contains(query, name) {
+				vector = append(vector, &model.Sample{
+					Metric: model.Metric{model.MetricNameLabel: model.LabelValue(name)},
+					Value:  model.SampleValue(count),
+				})
+			}
+		}
+		return vector, nil
+	}
+	for match, count := range q.matches {
+		if query == fmt.Sprintf("count({%s})", match) {
+			vector = append(vector, &model.Sample{Metric: model.Metric{}, Value: model.SampleValue(count)})
+		}
+	}
+	return vector, nil
+}
+
+func TestPromClientQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"status":"error","error":"unauthorized"}`)
+			return
+		}
+		if r.FormValue("query") != `count by (__name__) ({__name__=~"a|b|c"})` {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"status":"error","error":%q}`, "unexpected query "+r.FormValue("query"))
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+			`{"metric":{"__name__":"a"},"value":[1640995200,"3"]},`+
+			`{"metric":{"__name__":"b"},"value":[1640995200,"5"]}]}}`)
+	}))
+	defer server.Close()
+
+	ctx := context.TODO()
+	c := fake.NewFakeClient(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-token", Namespace: namespace},
+		Data:       map[string][]byte{"token": []byte("test-token\n")},
+	})
+	p, err := newPromClient(ctx, c, MetricsSource{
+		ServerURL:   server.URL,
+		TokenSecret: &KeyRef{Name: "prometheus-token", Key: "token"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the prometheus client: (%v)", err)
+	}
+	counts, err := countAllowlistSeries(ctx, p, MetricsAllowlist{NameList: []string{"a", "b", "c"}})
+	if err != nil {
+		t.Fatalf("Failed to count the series: (%v)", err)
+	}
+	expected := []seriesCount{
+		{Entry: "a", Type: "names", Series: 3},
+		{Entry: "b", Type: "names", Series: 5},
+		{Entry: "c", Type: "names", Series: 0},
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("Wrong series counts, expected: %v, got: %v", expected, counts)
+	}
+
+	_, err = countAllowlistSeries(ctx, p, MetricsAllowlist{MatchList: []string{`__name__="d"`}})
+	if err == nil {
+		t.Fatalf("Missed the error for the failed query")
+	}
+}
+
+func TestApplySeriesBudget(t *testing.T) {
+	allowlist := MetricsAllowlist{
+		NameList:  []string{"a", "b", "c"},
+		MatchList: []string{`__name__="d"`, `__name__="e"`},
+		RuleList:  []Rule{{Record: "f", Expr: "sum(a)"}},
+	}
+	counts := []seriesCount{
+		{Entry: "a", Type: "names", Series: 100},
+		{Entry: "b", Type: "names", Series: 50},
+		{Entry: "c", Type: "names", Series: 30},
+		{Entry: `__name__="d"`, Type: "matches", Series: 40},
+		{Entry: `__name__="e"`, Type: "matches", Series: 0},
+	}
+
+	result, dropped := applySeriesBudget(allowlist, counts, 0)
+	if !reflect.DeepEqual(result, allowlist) || len(dropped) != 0 {
+		t.Fatalf("Entries dropped without the budget: %v", dropped)
+	}
+	result, dropped = applySeriesBudget(allowlist, counts, 220)
+	if !reflect.DeepEqual(result, allowlist) || len(dropped) != 0 {
+		t.Fatalf("Entries dropped within the budget: %v", dropped)
+	}
+
+	// the lowest-priority entries with series are dropped until the budget is met
+	result, dropped = applySeriesBudget(allowlist, counts, 150)
+	expected := MetricsAllowlist{
+		NameList:  []string{"a", "b"},
+		MatchList: []string{`__name__="e"`},
+		RuleList:  allowlist.RuleList,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Wrong allowlist within the budget, expected: %v, got: %v", expected, result)
+	}
+	if !reflect.DeepEqual(dropped, []seriesCount{counts[3], counts[2]}) {
+		t.Fatalf("Wrong dropped entries: %v", dropped)
+	}
+
+	top := topSeriesCounts(counts, 2)
+	if !reflect.DeepEqual(top, []seriesCount{counts[0], counts[1]}) {
+		t.Fatalf("Wrong top entries: %v", top)
+	}
+}
+
+func TestApplyCardinalityBudget(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+	querier := &fakeSeriesQuerier{
+		names:   map[string]int{"a": 100, "b": 50},
+		matches: map[string]int{`__name__="c"`: 80},
+	}
+	r := &ObservabilityAddonReconciler{Client: c, seriesQuerier: querier}
+	allowlist := MetricsAllowlist{
+		NameList:  []string{"a", "b"},
+		MatchList: []string{`__name__="c"`},
+	}
+	config := EndpointConfig{CardinalityBudget: CardinalityBudget{Enabled: true, MaxSeries: 200, TopN: 2}}
+
+	result, status := r.applyCardinalityBudget(ctx, config, allowlist, false)
+	if status.Type != "CardinalityBudgetExceeded" ||
+		!strings.Contains(status.Details, `a: 100, {__name__="c"}: 80`) {
+		t.Fatalf("Wrong cardinality status: (%v)", status)
+	}
+	if len(result.MatchList) != 0 || len(result.NameList) != 2 {
+		t.Fatalf("Wrong allowlist within the budget: (%v)", result)
+	}
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: cardinalityStatusName, Namespace: namespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cardinality status configmap: (%v)", err)
+	}
+	published := cardinalityStatus{}
+	err = yaml.Unmarshal([]byte(cm.Data[cardinalityStatusKey]), &published)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal the cardinality status: (%v)", err)
+	}
+	if published.TotalSeries != 230 || published.CollectedSeries != 150 ||
+		len(published.TopEntries) != 2 || len(published.DroppedEntries) != 1 {
+		t.Fatalf("Wrong cardinality status: (%v)", published)
+	}
+
+	// the counts are cached within the interval
+	queries := querier.queries
+	_, status = r.applyCardinalityBudget(ctx, config, allowlist, false)
+	if querier.queries != queries || status.Type != "CardinalityBudgetExceeded" {
+		t.Fatalf("The series are counted again within the interval")
+	}
+
+	config.CardinalityBudget.MaxSeries = 0
+	result, status = r.applyCardinalityBudget(ctx, config, allowlist, false)
+	if status.Type != "WithinCardinalityBudget" || !reflect.DeepEqual(result, allowlist) {
+		t.Fatalf("Wrong cardinality status without the budget: (%v)", status)
+	}
+
+	_, status = r.applyCardinalityBudget(ctx, EndpointConfig{}, allowlist, false)
+	if status.Type != "WithinCardinalityBudget" {
+		t.Fatalf("Wrong cardinality status when disabled: (%v)", status)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: cardinalityStatusName, Namespace: namespace}, &corev1.ConfigMap{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("The cardinality status configmap is not deleted")
+	}
+}
+
+func TestApplyCardinalityBudgetUserWorkload(t *testing.T) {
+	ctx := context.TODO()
+	querier := &fakeSeriesQuerier{
+		names: map[string]int{"a": 100, "app_requests_total": 80},
+	}
+	r := &ObservabilityAddonReconciler{Client: fake.NewFakeClient(), seriesQuerier: querier}
+	allowlist := MetricsAllowlist{
+		NameList:     []string{"a"},
+		UserWorkload: &MetricsAllowlist{NameList: []string{"app_requests_total"}},
+	}
+	config := EndpointConfig{CardinalityBudget: CardinalityBudget{Enabled: true, MaxSeries: 150}}
+
+	// the user workload entries are not counted without the user workload metrics
+	result, status := r.applyCardinalityBudget(ctx, config, allowlist, false)
+	if status.Type != "WithinCardinalityBudget" || !reflect.DeepEqual(result, allowlist) {
+		t.Fatalf("Wrong cardinality status without the user workload metrics: (%v)", status)
+	}
+
+	// the user workload entries are counted and dropped before the platform ones
+	result, status = r.applyCardinalityBudget(ctx, config, allowlist, true)
+	if status.Type != "CardinalityBudgetExceeded" ||
+		!strings.Contains(status.Details, "180 series") ||
+		!strings.Contains(status.Details, "dropped userWorkload/app_requests_total: 80") {
+		t.Fatalf("Wrong cardinality status with the user workload metrics: (%v)", status)
+	}
+	if !reflect.DeepEqual(result.NameList, []string{"a"}) || len(result.UserWorkload.NameList) != 0 {
+		t.Fatalf("Wrong allowlist within the budget: (%v), (%v)", result, result.UserWorkload)
+	}
+	if len(allowlist.UserWorkload.NameList) != 1 {
+		t.Fatalf("The user workload allowlist is changed in place: (%v)", allowlist.UserWorkload)
+	}
+}
+
+func TestSeriesCountFailureCached(t *testing.T) {
+	ctx := context.TODO()
+	querier := &fakeSeriesQuerier{err: fmt.Errorf("connection refused")}
+	r := &ObservabilityAddonReconciler{Client: fake.NewFakeClient(), seriesQuerier: querier}
+	allowlist := MetricsAllowlist{NameList: []string{"a"}}
+	config := EndpointConfig{CardinalityBudget: CardinalityBudget{Enabled: true, MaxSeries: 150}}
+
+	for i := 0; i < 3; i++ {
+		result, status := r.applyCardinalityBudget(ctx, config, allowlist, false)
+		if status.Type != "CardinalityUnknown" || !reflect.DeepEqual(result, allowlist) {
+			t.Fatalf("Wrong cardinality status for the failed count: (%v)", status)
+		}
+	}
+	if querier.queries != 1 {
+		t.Fatalf("The failed count is retried in every reconcile: %d queries", querier.queries)
+	}
+
+	// the failed count is retried after the retry period
+	querier.err = nil
+	querier.names = map[string]int{"a": 100}
+	r.seriesCounts.countedAt = r.seriesCounts.countedAt.Add(-seriesCountRetryPeriod)
+	_, status := r.applyCardinalityBudget(ctx, config, allowlist, false)
+	if status.Type != "WithinCardinalityBudget" || querier.queries != 2 {
+		t.Fatalf("The failed count is not retried after the retry period: (%v)", status)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/stolostron/endpoint-metrics-operator/pkg/util"
+)
+
+const (
+	collectorConfigName      = "metrics-collector-config"
+	collectorConfigKey       = "collector.yaml"
+	collectorConfigVolName   = "collector-config"
+	collectorConfigMountPath = "/etc/metrics-collector"
+	collectorConfigHashKey   = "observability.open-cluster-management.io/collector-config-hash"
+)
+
+// CollectorConfig is the configuration file rendered for the metrics collector
+type CollectorConfig struct {
+	Matches        []string          `yaml:"matches,omitempty"`
+	Renames        map[string]string `yaml:"renames,omitempty"`
+	RecordingRules []RecordingRule   `yaml:"recordingRules,omitempty"`
+}
+
+// RecordingRule is the recording rule evaluated by the metrics collector
+type RecordingRule struct {
+	Name  string `yaml:"name"`
+	Query string `yaml:"query"`
+}
+
+// renderCollectorConfig renders the metrics collector configuration file from the allowlist
+func renderCollectorConfig(allowlist MetricsAllowlist) (string, error) {
+	config := CollectorConfig{}
+	for _, metrics := range allowlist.NameList {
+		config.Matches = append(config.Matches, fmt.Sprintf("{__name__=\"%s\"}", metrics))
+	}
+	for _, match := range allowlist.MatchList {
+		config.Matches = append(config.Matches, fmt.Sprintf("{%s}", match))
+	}
+	config.Renames = allowlist.ReNameMap
+	for _, rule := range allowlist.RuleList {
+		config.RecordingRules = append(config.RecordingRules, RecordingRule{Name: rule.Record, Query: rule.Expr})
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// recordCollectorConfigEntries records the number of the entries in the config data applied to the configmap
+// with the name, which is the last known good one while the allowlist is invalid
+func recordCollectorConfigEntries(name string, data string) {
+	config := CollectorConfig{}
+	if err := yaml.Unmarshal([]byte(data), &config); err != nil {
+		log.Error(err, "Failed to parse the metrics collector config", "name", name)
+		return
+	}
+	util.RecordCollectorConfigEntries(name, len(config.Matches), len(config.Renames), len(config.RecordingRules))
+}
+
+func configHash(data string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+}
+
+// updateCollectorConfig creates or updates the configmap with the name containing the metrics collector configuration,
+// and returns the hash of the deployed configuration.
+// If keepAllowlist is true, the existing configuration is kept as the last known good one.
+func updateCollectorConfig(ctx context.Context, c client.Client, name string,
+	allowlist MetricsAllowlist, keepAllowlist bool) (string, error) {
+	data, err := renderCollectorConfig(allowlist)
+	if err != nil {
+		log.Error(err, "Failed to render the metrics collector config")
+		return "", err
+	}
+	return applyCollectorConfig(ctx, c, name, data, nil, keepAllowlist)
+}
+
+// applyCollectorConfig creates or updates the configmap with the name containing the rendered configuration
+// and the annotations, and returns the hash of the deployed configuration.
+// If keepAllowlist is true, the existing configuration is kept as the last known good one.
+func applyCollectorConfig(ctx context.Context, c client.Client, name string, data string,
+	annotations map[string]string, keepAllowlist bool) (string, error) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		Data: map[string]string{collectorConfigKey: data},
+	}
+	for k, v := range annotations {
+		cm.Annotations[k] = v
+	}
+
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: name,
+		Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			err = c.Create(ctx, cm)
+			if err != nil {
+				log.Error(err, "Failed to create the metrics collector config configmap", "name", name)
+				return "", err
+			}
+			log.Info("Created the metrics collector config configmap", "name", name)
+			recordCollectorConfigEntries(name, data)
+			return configHash(data), nil
+		}
+		log.Error(err, "Failed to check the metrics collector config configmap", "name", name)
+		return "", err
+	}
+
+	if keepAllowlist {
+		log.Info("Keep the last known good metrics collector config")
+		recordCollectorConfigEntries(name, found.Data[collectorConfigKey])
+		return configHash(found.Data[collectorConfigKey]), nil
+	}
+	changed := found.Data[collectorConfigKey] != data
+	for k, v := range annotations {
+		if found.Annotations[k] != v {
+			changed = true
+		}
+	}
+	if changed {
+		cm.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+		err = c.Update(ctx, cm)
+		if err != nil {
+			log.Error(err, "Failed to update the metrics collector config configmap", "name", name)
+			return "", err
+		}
+		log.Info("Updated the metrics collector config configmap", "name", name)
+		if found.Data[collectorConfigKey] != data {
+			recordNormal(ctx, eventCollectorConfigUpdated, "Updated the metrics collector config configmap %s", name)
+		}
+	}
+	recordCollectorConfigEntries(name, data)
+	return configHash(data), nil
+}
+
+func deleteCollectorConfig(ctx context.Context, c client.Client, name string) error {
+	util.DeleteCollectorConfigEntries(name)
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: name,
+		Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("The metrics collector config configmap does not exist", "name", name)
+			return nil
+		}
+		log.Error(err, "Failed to check the metrics collector config configmap", "name", name)
+		return err
+	}
+	err = c.Delete(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to delete the metrics collector config configmap", "name", name)
+		return err
+	}
+	log.Info("metrics collector config configmap deleted", "name", name)
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// collectorConfigMatches returns the number of the matches recorded for the metrics collector config
+func collectorConfigMatches(t *testing.T, name string) float64 {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather the metrics: (%v)", err)
+	}
+	for _, f := range families {
+		if f.GetName() != "endpoint_metrics_operator_metrics_collector_config_entries" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["config"] == name && labels["type"] == "matches" {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	t.Fatalf("No entries recorded for the metrics collector config %s", name)
+	return 0
+}
+
+func TestRenderCollectorConfig(t *testing.T) {
+	allowlist := MetricsAllowlist{
+		NameList:  []string{"a"},
+		MatchList: []string{`__name__="b",job="c"`},
+		ReNameMap: map[string]string{"e": "e1", "d": "d1"},
+		RuleList:  []Rule{{Record: "f", Expr: "sum(g)"}},
+	}
+	expected := `matches:
+- '{__name__="a"}'
+- '{__name__="b",job="c"}'
+renames:
+  d: d1
+  e: e1
+recordingRules:
+- name: f
+  query: sum(g)
+`
+	data, err := renderCollectorConfig(allowlist)
+	if err != nil {
+		t.Fatalf("Failed to render collector config: (%v)", err)
+	}
+	if data != expected {
+		t.Fatalf("Wrong collector config, expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestUpdateCollectorConfig(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+	hash, err := updateCollectorConfig(ctx, c, collectorConfigName, MetricsAllowlist{NameList: []string{"a"}}, false)
+	if err != nil {
+		t.Fatalf("Failed to create collector config: (%v)", err)
+	}
+	newHash, err := updateCollectorConfig(ctx, c, collectorConfigName, MetricsAllowlist{NameList: []string{"a", "b"}}, false)
+	if err != nil {
+		t.Fatalf("Failed to update collector config: (%v)", err)
+	}
+	if newHash == hash {
+		t.Fatalf("Config hash not changed after allowlist updated")
+	}
+	keptHash, err := updateCollectorConfig(ctx, c, collectorConfigName, MetricsAllowlist{}, true)
+	if err != nil {
+		t.Fatalf("Failed to keep collector config: (%v)", err)
+	}
+	if keptHash != newHash {
+		t.Fatalf("Last known good collector config not kept")
+	}
+	// the entries of the kept config are recorded instead of the ones of the invalid allowlist
+	if n := collectorConfigMatches(t, collectorConfigName); n != 2 {
+		t.Fatalf("Wrong number of the matches recorded for the kept collector config: %v", n)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorConfigName, Namespace: namespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get collector config: (%v)", err)
+	}
+	if configHash(cm.Data[collectorConfigKey]) != newHash {
+		t.Fatalf("Wrong content in collector config configmap: (%v)", cm.Data[collectorConfigKey])
+	}
+	err = deleteCollectorConfig(ctx, c, collectorConfigName)
+	if err != nil {
+		t.Fatalf("Failed to delete collector config: (%v)", err)
+	}
+	err = deleteCollectorConfig(ctx, c, collectorConfigName)
+	if err != nil {
+		t.Fatalf("Run into error when try to delete collector config twice: (%v)", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"fmt"
+
+	yamltool "github.com/ghodss/yaml"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// the env of the metrics collector container set by the operator, which cannot be overridden
+var managedCollectorEnv = []string{"FROM", "TO", "POD_NAME"}
+
+// CollectorPod is the local override of the pod template of the metrics collectors. The fields follow the pod spec,
+// so it is decoded from the json form of the yaml with the unknown fields rejected.
+type CollectorPod struct {
+	NodeSelector      map[string]string          `json:"nodeSelector,omitempty"`
+	Tolerations       []corev1.Toleration        `json:"tolerations,omitempty"`
+	Affinity          *corev1.Affinity           `json:"affinity,omitempty"`
+	PriorityClassName string                     `json:"priorityClassName,omitempty"`
+	SecurityContext   *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+	// ContainerSecurityContext is the security context of the metrics collector container
+	ContainerSecurityContext *corev1.SecurityContext `json:"containerSecurityContext,omitempty"`
+	// Env is merged into the env of the metrics collector container, e.g. HTTP_PROXY
+	Env []corev1.EnvVar `json:"env,omitempty"`
+}
+
+// podOverridesError is the error of the invalid collectorPod in the endpoint config
+type podOverridesError struct {
+	err error
+}
+
+func (e *podOverridesError) Error() string {
+	return fmt.Sprintf("invalid collectorPod: %v", e.err)
+}
+
+// UnmarshalYAML decodes the pod spec fields with their json names
+func (p *CollectorPod) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return &podOverridesError{err: err}
+	}
+	type plain CollectorPod
+	if err := yamltool.UnmarshalStrict(data, (*plain)(p), yamltool.DisallowUnknownFields); err != nil {
+		return &podOverridesError{err: err}
+	}
+	return nil
+}
+
+func (p CollectorPod) validate() error {
+	for _, t := range p.Tolerations {
+		switch t.Operator {
+		case "", corev1.TolerationOpEqual:
+		case corev1.TolerationOpExists:
+			if t.Value != "" {
+				return &podOverridesError{err: fmt.Errorf("value must be empty for the toleration of %q with Exists", t.Key)}
+			}
+		default:
+			return &podOverridesError{err: fmt.Errorf("invalid operator %q in the toleration of %q", t.Operator, t.Key)}
+		}
+		switch t.Effect {
+		case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return &podOverridesError{err: fmt.Errorf("invalid effect %q in the toleration of %q", t.Effect, t.Key)}
+		}
+	}
+	for _, env := range p.Env {
+		if env.Name == "" {
+			return &podOverridesError{err: fmt.Errorf("name is required for env")}
+		}
+		if contains(managedCollectorEnv, env.Name) {
+			return &podOverridesError{err: fmt.Errorf("env %s is set by the operator", env.Name)}
+		}
+	}
+	return nil
+}
+
+// apply merges the overrides into the pod spec of the metrics collector,
+// the affinity of the overrides replaces the generated one for each of its kinds
+func (p CollectorPod) apply(spec *corev1.PodSpec) {
+	if len(p.NodeSelector) != 0 {
+		spec.NodeSelector = p.NodeSelector
+	}
+	if len(p.Tolerations) != 0 {
+		spec.Tolerations = p.Tolerations
+	}
+	if p.Affinity != nil {
+		if spec.Affinity == nil {
+			spec.Affinity = &corev1.Affinity{}
+		}
+		if p.Affinity.NodeAffinity != nil {
+			spec.Affinity.NodeAffinity = p.Affinity.NodeAffinity
+		}
+		if p.Affinity.PodAffinity != nil {
+			spec.Affinity.PodAffinity = p.Affinity.PodAffinity
+		}
+		if p.Affinity.PodAntiAffinity != nil {
+			spec.Affinity.PodAntiAffinity = p.Affinity.PodAntiAffinity
+		}
+	}
+	if p.PriorityClassName != "" {
+		spec.PriorityClassName = p.PriorityClassName
+	}
+	if p.SecurityContext != nil {
+		spec.SecurityContext = p.SecurityContext
+	}
+	container := &spec.Containers[0]
+	if p.ContainerSecurityContext != nil {
+		container.SecurityContext = p.ContainerSecurityContext
+	}
+	for _, env := range p.Env {
+		// the env such as HTTP_PROXY replaces the one from the cluster proxy
+		replaced := false
+		for i := range container.Env {
+			if container.Env[i].Name == env.Name {
+				container.Env[i] = env
+				replaced = true
+			}
+		}
+		if !replaced {
+			container.Env = append(container.Env, env)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+func TestCollectorPodOverrides(t *testing.T) {
+	ctx := context.TODO()
+	config, err := getEndpointConfig(ctx, fake.NewFakeClient(newEndpointConfigCM(`
+highAvailability:
+  enabled: true
+collectorPod:
+  nodeSelector:
+    node-role.kubernetes.io/infra: ""
+  tolerations:
+  - key: node-role.kubernetes.io/infra
+    operator: Exists
+    effect: NoSchedule
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+        - matchExpressions:
+          - key: topology.kubernetes.io/zone
+            operator: In
+            values:
+            - edge-a
+  priorityClassName: system-cluster-critical
+  containerSecurityContext:
+    runAsNonRoot: true
+  env:
+  - name: HTTP_PROXY
+    value: http://proxy.example.com:3128
+`)))
+	if err != nil {
+		t.Fatalf("Failed to get endpoint config: (%v)", err)
+	}
+
+	spec := createDeployment(testClusterID, "", oashared.ObservabilityAddonSpec{}, HubInfo{}, *config, "", 1).
+		Spec.Template.Spec
+	if spec.NodeSelector["node-role.kubernetes.io/infra"] != "" || len(spec.NodeSelector) != 1 {
+		t.Fatalf("Wrong node selector: (%v)", spec.NodeSelector)
+	}
+	if len(spec.Tolerations) != 1 || spec.Tolerations[0].Operator != corev1.TolerationOpExists {
+		t.Fatalf("Wrong tolerations: (%v)", spec.Tolerations)
+	}
+	if spec.Affinity.NodeAffinity == nil || spec.Affinity.PodAntiAffinity == nil {
+		t.Fatalf("The node affinity is not merged with the generated anti-affinity: (%v)", spec.Affinity)
+	}
+	if spec.PriorityClassName != "system-cluster-critical" {
+		t.Fatalf("Wrong priority class: (%s)", spec.PriorityClassName)
+	}
+	container := spec.Containers[0]
+	if container.SecurityContext == nil || !*container.SecurityContext.RunAsNonRoot {
+		t.Fatalf("Wrong container security context: (%v)", container.SecurityContext)
+	}
+	if env := container.Env[len(container.Env)-1]; env.Name != "HTTP_PROXY" || env.Value != "http://proxy.example.com:3128" {
+		t.Fatalf("Wrong env: (%v)", container.Env)
+	}
+
+	for _, data := range []string{
+		"collectorPod:\n  nodeSelectr:\n    role: infra\n",
+		"collectorPod:\n  tolerations:\n  - key: infra\n    operator: Maybe\n",
+		"collectorPod:\n  tolerations:\n  - key: infra\n    operator: Exists\n    value: \"true\"\n",
+		"collectorPod:\n  tolerations:\n  - key: infra\n    effect: NoRun\n",
+		"collectorPod:\n  env:\n  - name: FROM\n    value: http://prometheus:9090\n",
+		"collectorPod:\n  env:\n  - value: http://proxy.example.com:3128\n",
+		"collectorPod:\n  priorityClassName: [critical]\n",
+	} {
+		_, err = getEndpointConfig(ctx, fake.NewFakeClient(newEndpointConfigCM(data)))
+		if _, ok := err.(*podOverridesError); !ok {
+			t.Fatalf("Missed the error for invalid collector pod overrides: (%s), got: (%v)", data, err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	endpointConfigName = "endpoint-observability-config"
+	endpointConfigKey  = "config.yaml"
+	defaultPromURL     = "https://prometheus-k8s.openshift-monitoring.svc:9091"
+	defaultUWLPromURL  = "https://prometheus-user-workload.openshift-user-workload-monitoring.svc:9092"
+)
+
+// EndpointConfig is the local configuration for the observability components in the managed cluster
+type EndpointConfig struct {
+	// Platform is one of auto, openshift and kubernetes, the platform is detected if it is auto or empty
+	Platform        string          `yaml:"platform,omitempty"`
+	MetricsSource   MetricsSource   `yaml:"metricsSource,omitempty"`
+	AlertForwarding AlertForwarding `yaml:"alertForwarding,omitempty"`
+	// UserWorkloadMetrics is the configuration for collecting the metrics from the user workload monitoring stack
+	UserWorkloadMetrics UserWorkloadMetrics `yaml:"userWorkloadMetrics,omitempty"`
+	// CardinalityBudget is the budget of the series collected by the metrics collector
+	CardinalityBudget CardinalityBudget `yaml:"cardinalityBudget,omitempty"`
+	// Sharding is the configuration for splitting the allowlist across multiple metrics collectors
+	Sharding CollectorSharding `yaml:"sharding,omitempty"`
+	// HighAvailability is the configuration for running the metrics collectors with a standby replica
+	HighAvailability CollectorHighAvailability `yaml:"highAvailability,omitempty"`
+	// CollectorPod is merged into the pod template of the metrics collectors
+	CollectorPod CollectorPod `yaml:"collectorPod,omitempty"`
+	// HubCA is the additional CA trusted for the connections to the hub
+	HubCA HubCA `yaml:"hubCA,omitempty"`
+	// clusterProxy is the detected cluster-wide proxy, which is not configured locally
+	clusterProxy *clusterProxy
+}
+
+// CollectorSharding is the configuration for splitting the allowlist across multiple metrics collectors
+type CollectorSharding struct {
+	// Shards is the number of the metrics collectors, the metric names are hashed to split the allowlist.
+	// The default is 1, which runs a single metrics collector.
+	Shards int `yaml:"shards,omitempty"`
+}
+
+// CollectorHighAvailability is the configuration for running the metrics collectors with a standby replica
+type CollectorHighAvailability struct {
+	// Enabled runs two replicas of every metrics collector spread across the nodes,
+	// only the replica holding the lease pushes the metrics
+	Enabled bool `yaml:"enabled,omitempty"`
+}
+
+// HubCA is the additional CA trusted by the metrics collectors and the alert forwarding for the hub
+type HubCA struct {
+	// CABundle is the key in the configmap containing the additional CA bundle, e.g. the corporate CA chain
+	CABundle *KeyRef `yaml:"caBundle,omitempty"`
+	// InjectTrustedCABundle is true to trust the CA bundle injected by the cluster network operator of openshift
+	InjectTrustedCABundle bool `yaml:"injectTrustedCABundle,omitempty"`
+}
+
+// enabled returns whether the hub CA is combined with the additional CA bundles
+func (h HubCA) enabled() bool {
+	return h.CABundle != nil || h.InjectTrustedCABundle
+}
+
+// shards returns the number of the metrics collectors
+func (s CollectorSharding) shards() int {
+	if s.Shards < 1 {
+		return 1
+	}
+	return s.Shards
+}
+
+// CardinalityBudget is the configuration for counting the series of the allowlist entries in the metrics source
+type CardinalityBudget struct {
+	// Enabled is true to count the series of the names and matches in the allowlist
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MaxSeries is the maximum number of the series collected, the lowest-priority entries are dropped
+	// if it is exceeded. The series are only counted and reported if it is 0.
+	MaxSeries int `yaml:"maxSeries,omitempty"`
+	// TopN is the number of the entries with the most series reported, the default is 10
+	TopN int `yaml:"topN,omitempty"`
+	// Interval is the minimum interval between the counts, e.g. 10m, the default is 10m
+	Interval string `yaml:"interval,omitempty"`
+}
+
+// UserWorkloadMetrics is the configuration for collecting the metrics from the user workload monitoring stack
+type UserWorkloadMetrics struct {
+	// Enabled is true to run the collector for the metrics in the userWorkload section of the allowlist
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MetricsSource is the user workload Prometheus or Thanos Querier,
+	// the user workload Prometheus in openshift-user-workload-monitoring is used by default
+	MetricsSource MetricsSource `yaml:"metricsSource,omitempty"`
+}
+
+// MetricsSource is the server configuration the metrics collector gets metrics from
+type MetricsSource struct {
+	// ServerURL is the url of the Prometheus or Thanos Querier to federate from
+	ServerURL string `yaml:"serverURL,omitempty"`
+	// CAConfigMap is the configmap containing the CA certificate to verify the server
+	CAConfigMap *KeyRef `yaml:"caConfigMap,omitempty"`
+	// TokenSecret is the secret containing the bearer token to access the server
+	TokenSecret *KeyRef `yaml:"tokenSecret,omitempty"`
+}
+
+// AlertForwarding is the configuration for forwarding the alerts of the cluster monitoring stack to the hub alertmanager
+type AlertForwarding struct {
+	// Enabled is false to stop forwarding the alerts, the alerts are forwarded by default
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Timeout is the timeout used when sending the alerts, e.g. 10s
+	Timeout string `yaml:"timeout,omitempty"`
+	// PathPrefix is the path prefix added in front of the push endpoint path, the default is /
+	PathPrefix string `yaml:"pathPrefix,omitempty"`
+	// Endpoints are the hub alertmanager endpoints, the alertmanager endpoint in the hub info is used if it is empty
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// ProxyURL is the proxy to send the alerts to the hub alertmanager,
+	// the additional alertmanager config of CMO has no proxy setting to apply it
+	ProxyURL string `yaml:"proxyURL,omitempty"`
+	// UserWorkload is true to forward the alerts of the user workload monitoring stack too
+	UserWorkload bool `yaml:"userWorkload,omitempty"`
+	// caBundle is the additional CA bundle trusted for the hub alertmanager, which is configured in hubCA
+	caBundle string
+}
+
+// isEnabled returns whether the alerts are forwarded to the hub alertmanager
+func (f AlertForwarding) isEnabled() bool {
+	return f.Enabled == nil || *f.Enabled
+}
+
+// KeyRef refers to a key in a configmap or secret in the addon namespace
+type KeyRef struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+// getEndpointConfig reads the local configuration, the default values are used if it does not exist
+func getEndpointConfig(ctx context.Context, c client.Client) (*EndpointConfig, error) {
+	config := &EndpointConfig{}
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: endpointConfigName, Namespace: namespace}, cm)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "Failed to get the endpoint config", "name", endpointConfigName)
+			return nil, err
+		}
+	} else if cm.Data[endpointConfigKey] != "" {
+		err = yaml.Unmarshal([]byte(cm.Data[endpointConfigKey]), config)
+		if err != nil {
+			log.Error(err, "Failed to unmarshal the endpoint config", "name", endpointConfigName)
+			return nil, err
+		}
+	}
+	if err := config.validate(); err != nil {
+		log.Error(err, "Invalid endpoint config", "name", endpointConfigName)
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *EndpointConfig) validate() error {
+	switch c.Platform {
+	case "", platformAuto, platformOpenShift, platformKubernetes:
+	default:
+		return fmt.Errorf("invalid platform %q, should be one of %s, %s and %s",
+			c.Platform, platformAuto, platformOpenShift, platformKubernetes)
+	}
+	if err := c.MetricsSource.validate("metricsSource"); err != nil {
+		return err
+	}
+	if err := c.UserWorkloadMetrics.MetricsSource.validate("userWorkloadMetrics.metricsSource"); err != nil {
+		return err
+	}
+	if err := c.CardinalityBudget.validate(); err != nil {
+		return err
+	}
+	if c.Sharding.Shards < 0 {
+		return fmt.Errorf("invalid sharding.shards %d, should not be negative", c.Sharding.Shards)
+	}
+	if err := c.CollectorPod.validate(); err != nil {
+		return err
+	}
+	if ref := c.HubCA.CABundle; ref != nil && (ref.Name == "" || ref.Key == "") {
+		return fmt.Errorf("name and key are required for hubCA.caBundle")
+	}
+	return c.AlertForwarding.validate()
+}
+
+func (b CardinalityBudget) validate() error {
+	if b.MaxSeries < 0 {
+		return fmt.Errorf("invalid cardinalityBudget.maxSeries %d, should not be negative", b.MaxSeries)
+	}
+	if b.TopN < 0 {
+		return fmt.Errorf("invalid cardinalityBudget.topN %d, should not be negative", b.TopN)
+	}
+	if b.Interval != "" {
+		if _, err := model.ParseDuration(b.Interval); err != nil {
+			return fmt.Errorf("invalid cardinalityBudget.interval %q: %v", b.Interval, err)
+		}
+	}
+	return nil
+}
+
+func (s MetricsSource) validate(field string) error {
+	if s.ServerURL != "" {
+		u, err := url.Parse(s.ServerURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid %s.serverURL %q", field, s.ServerURL)
+		}
+	}
+	if ref := s.CAConfigMap; ref != nil && (ref.Name == "" || ref.Key == "") {
+		return fmt.Errorf("name and key are required for %s.caConfigMap", field)
+	}
+	if ref := s.TokenSecret; ref != nil && (ref.Name == "" || ref.Key == "") {
+		return fmt.Errorf("name and key are required for %s.tokenSecret", field)
+	}
+	return nil
+}
+
+func (f AlertForwarding) validate() error {
+	if f.Timeout != "" {
+		if _, err := model.ParseDuration(f.Timeout); err != nil {
+			return fmt.Errorf("invalid alertForwarding.timeout %q: %v", f.Timeout, err)
+		}
+	}
+	if f.PathPrefix != "" && !strings.HasPrefix(f.PathPrefix, "/") {
+		return fmt.Errorf("invalid alertForwarding.pathPrefix %q, should start with /", f.PathPrefix)
+	}
+	for _, endpoint := range f.Endpoints {
+		if alertmanagerHost(endpoint) == "" {
+			return fmt.Errorf("invalid alertForwarding.endpoints %q", endpoint)
+		}
+	}
+	if f.ProxyURL != "" {
+		u, err := url.Parse(f.ProxyURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid alertForwarding.proxyURL %q", f.ProxyURL)
+		}
+	}
+	return nil
+}
+
+// alertmanagerHost returns the host and port of the alertmanager endpoint, which can be the url or host:port
+func alertmanagerHost(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// getSourceService returns the service behind the metrics source url.
+// It returns false if the url does not point to an in-cluster service.
+func getSourceService(serverURL string) (string, string, bool) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) >= 3 && parts[2] == "svc" {
+		return parts[0], parts[1], true
+	}
+	return "", "", false
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+func newEndpointConfigCM(data string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      endpointConfigName,
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			endpointConfigKey: data,
+		},
+	}
+}
+
+func TestGetEndpointConfig(t *testing.T) {
+	ctx := context.TODO()
+	config, err := getEndpointConfig(ctx, fake.NewFakeClient())
+	if err != nil {
+		t.Fatalf("Failed to get default endpoint config: (%v)", err)
+	}
+	if config.MetricsSource.ServerURL != "" || config.Platform != "" {
+		t.Fatalf("Wrong default endpoint config: (%v)", config)
+	}
+
+	config, err = getEndpointConfig(ctx, fake.NewFakeClient(newEndpointConfigCM(`
+metricsSource:
+  serverURL: https://thanos-querier.openshift-monitoring.svc:9091
+  caConfigMap:
+    name: thanos-ca
+    key: ca.crt
+  tokenSecret:
+    name: thanos-token
+    key: token
+`)))
+	if err != nil {
+		t.Fatalf("Failed to get endpoint config: (%v)", err)
+	}
+	if config.MetricsSource.ServerURL != "https://thanos-querier.openshift-monitoring.svc:9091" ||
+		config.MetricsSource.CAConfigMap.Name != "thanos-ca" ||
+		config.MetricsSource.TokenSecret.Key != "token" {
+		t.Fatalf("Wrong metrics source: (%v)", config.MetricsSource)
+	}
+
+	config, err = getEndpointConfig(ctx, fake.NewFakeClient(newEndpointConfigCM(`
+alertForwarding:
+  enabled: false
+  timeout: 30s
+  pathPrefix: /alertmanager
+  endpoints:
+  - https://alertmanager-0.hub.example.com
+  - alertmanager-1.hub.example.com:443
+  proxyURL: http://proxy.example.com:3128
+`)))
+	if err != nil {
+		t.Fatalf("Failed to get endpoint config: (%v)", err)
+	}
+	if config.AlertForwarding.isEnabled() || len(config.AlertForwarding.Endpoints) != 2 ||
+		config.AlertForwarding.Timeout != "30s" {
+		t.Fatalf("Wrong alert forwarding: (%v)", config.AlertForwarding)
+	}
+
+	for _, data := range []string{
+		"metricsSource:\n  serverURL: not-a-url\n",
+		"metricsSource:\n  caConfigMap:\n    name: thanos-ca\n",
+		"metricsSource:\n  tokenSecret:\n    key: token\n",
+		"metricsSource: [",
+		"platform: eks\n",
+		"alertForwarding:\n  timeout: ten-seconds\n",
+		"alertForwarding:\n  pathPrefix: alertmanager\n",
+		"alertForwarding:\n  proxyURL: socks5://proxy:1080\n",
+		"cardinalityBudget:\n  maxSeries: -1\n",
+		"cardinalityBudget:\n  topN: -1\n",
+		"cardinalityBudget:\n  interval: ten-minutes\n",
+		"sharding:\n  shards: -1\n",
+	} {
+		_, err = getEndpointConfig(ctx, fake.NewFakeClient(newEndpointConfigCM(data)))
+		if err == nil {
+			t.Fatalf("Missed the error for invalid endpoint config: (%s)", data)
+		}
+	}
+}
+
+func TestGetSourceService(t *testing.T) {
+	caseList := []struct {
+		url       string
+		name      string
+		namespace string
+		ok        bool
+	}{
+		{defaultPromURL, promSvcName, promNamespace, true},
+		{"https://thanos-querier.openshift-monitoring.svc.cluster.local:9091", "thanos-querier", "openshift-monitoring", true},
+		{"http://prometheus.example.com", "", "", false},
+		{"http://prometheus:9090", "", "", false},
+	}
+	for _, c := range caseList {
+		name, ns, ok := getSourceService(c.url)
+		if name != c.name || ns != c.namespace || ok != c.ok {
+			t.Errorf("Wrong service for url %s: (%s, %s, %v)", c.url, name, ns, ok)
+		}
+	}
+}
+
+func TestCreateDeploymentWithMetricsSource(t *testing.T) {
+	config := EndpointConfig{
+		MetricsSource: MetricsSource{
+			ServerURL:   "https://thanos-querier.openshift-monitoring.svc:9091",
+			CAConfigMap: &KeyRef{Name: "thanos-ca", Key: "ca.crt"},
+			TokenSecret: &KeyRef{Name: "thanos-token", Key: "token"},
+		},
+	}
+	dep := createDeployment(testClusterID, "", oashared.ObservabilityAddonSpec{}, HubInfo{}, config, "", 1)
+	container := dep.Spec.Template.Spec.Containers[0]
+	if container.Env[0].Value != config.MetricsSource.ServerURL {
+		t.Fatalf("Wrong metrics source url: (%s)", container.Env[0].Value)
+	}
+	if !contains(container.Command, "--from-ca-file="+sourceCAMountPath+"/ca.crt") ||
+		!contains(container.Command, "--from-token-file="+sourceTokenMountPath+"/token") {
+		t.Fatalf("Wrong ca or token file in command: (%v)", container.Command)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// the reasons of the events emitted on the local observabilityaddon
+const (
+	eventMetricsCollectorCreated           = "MetricsCollectorCreated"
+	eventMetricsCollectorUpdated           = "MetricsCollectorUpdated"
+	eventMetricsCollectorDeleted           = "MetricsCollectorDeleted"
+	eventCollectorRestartedForCertRotation = "CollectorRestartedForCertRotation"
+	eventCollectorConfigUpdated            = "CollectorConfigUpdated"
+	eventClusterRoleBindingCreated         = "ClusterRoleBindingCreated"
+	eventClusterRoleBindingUpdated         = "ClusterRoleBindingUpdated"
+	eventClusterRoleBindingDeleted         = "ClusterRoleBindingDeleted"
+	eventCAConfigMapCreated                = "CAConfigMapCreated"
+	eventCAConfigMapDeleted                = "CAConfigMapDeleted"
+	eventClusterMonitoringConfigCreated    = "ClusterMonitoringConfigCreated"
+	eventClusterMonitoringConfigMerged     = "ClusterMonitoringConfigMerged"
+	eventClusterMonitoringConfigReverted   = "ClusterMonitoringConfigReverted"
+	eventInvalidAppAllowlist               = "InvalidAppAllowlist"
+	eventSeriesCountFailed                 = "SeriesCountFailed"
+)
+
+type eventsKey struct{}
+
+type eventTarget struct {
+	recorder record.EventRecorder
+	obj      runtime.Object
+}
+
+// withEvents returns the context carrying the recorder and the object the events are emitted on,
+// so that the resource functions can emit the events without the recorder in their arguments
+func withEvents(ctx context.Context, recorder record.EventRecorder, obj runtime.Object) context.Context {
+	if recorder == nil || obj == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, eventsKey{}, eventTarget{recorder: recorder, obj: obj})
+}
+
+// recordEvent emits the event if the context carries the recorder, otherwise it does nothing
+func recordEvent(ctx context.Context, eventtype, reason, messageFmt string, args ...interface{}) {
+	target, ok := ctx.Value(eventsKey{}).(eventTarget)
+	if !ok {
+		return
+	}
+	target.recorder.Eventf(target.obj, eventtype, reason, messageFmt, args...)
+}
+
+func recordNormal(ctx context.Context, reason, messageFmt string, args ...interface{}) {
+	recordEvent(ctx, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+func recordWarning(ctx context.Context, reason, messageFmt string, args ...interface{}) {
+	recordEvent(ctx, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+// drainEvents returns the events recorded by the fake recorder so far
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func hasEvent(events []string, eventtype, reason string) bool {
+	for _, e := range events {
+		if strings.HasPrefix(e, eventtype+" "+reason+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRecordEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	oba := newObservabilityAddon(name, testNamespace)
+
+	// no event is recorded if the context doesn't carry the recorder
+	recordNormal(context.TODO(), eventMetricsCollectorCreated, "Created the metrics collector deployment %s",
+		metricsCollectorName)
+	ctx := withEvents(context.TODO(), nil, oba)
+	recordNormal(ctx, eventMetricsCollectorCreated, "Created the metrics collector deployment %s",
+		metricsCollectorName)
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Fatalf("Unexpected events without the recorder: (%v)", events)
+	}
+
+	ctx = withEvents(context.TODO(), recorder, oba)
+	recordNormal(ctx, eventMetricsCollectorCreated, "Created the metrics collector deployment %s",
+		metricsCollectorName)
+	recordWarning(ctx, "HubSecretMissing", "%s", "secret not found")
+	events := drainEvents(recorder)
+	if len(events) != 2 {
+		t.Fatalf("Wrong number of events, expected: 2, got: %v", events)
+	}
+	if events[0] != "Normal MetricsCollectorCreated Created the metrics collector deployment "+metricsCollectorName {
+		t.Fatalf("Wrong normal event: (%s)", events[0])
+	}
+	if !hasEvent(events, "Warning", "HubSecretMissing") {
+		t.Fatalf("Warning event not recorded: (%v)", events)
+	}
+}
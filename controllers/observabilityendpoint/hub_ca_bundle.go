@@ -0,0 +1,150 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// the secret containing the hub CA combined with the additional CA bundles, mounted in place of the hub CA
+	hubCABundleName = "metrics-collector-hub-ca-bundle"
+	hubCABundleKey  = "ca.crt"
+)
+
+// combineCABundles returns the certificates in the bundles without the duplicated ones
+func combineCABundles(bundles ...string) string {
+	seen := map[string]bool{}
+	var buf bytes.Buffer
+	for _, bundle := range bundles {
+		rest := []byte(bundle)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" || seen[string(block.Bytes)] {
+				continue
+			}
+			seen[string(block.Bytes)] = true
+			_ = pem.Encode(&buf, &pem.Block{Type: block.Type, Bytes: block.Bytes})
+		}
+	}
+	return buf.String()
+}
+
+// getHubCABundle returns the additional CA bundles trusted for the hub, the injected trusted CA bundle is empty
+// until the cluster network operator injects it
+func getHubCABundle(ctx context.Context, c client.Client, hubCA HubCA) (string, error) {
+	bundles := []string{}
+	if ref := hubCA.CABundle; ref != nil {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, cm)
+		if err != nil {
+			log.Error(err, "Failed to get the hub CA bundle configmap", "name", ref.Name)
+			return "", err
+		}
+		bundle := combineCABundles(cm.Data[ref.Key])
+		if bundle == "" {
+			return "", fmt.Errorf("no certificate found in the key %s of the configmap %s for hubCA.caBundle",
+				ref.Key, ref.Name)
+		}
+		bundles = append(bundles, bundle)
+	}
+	if hubCA.InjectTrustedCABundle {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: trustedCABundleName, Namespace: namespace}, cm)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to get the trusted CA bundle configmap", "name", trustedCABundleName)
+			return "", err
+		}
+		bundles = append(bundles, cm.Data[trustedCABundleKey])
+	}
+	return combineCABundles(bundles...), nil
+}
+
+// updateHubCABundle creates or updates the secret combining the hub CA with the additional CA bundles
+// for the metrics collectors, the secret is deleted if no additional CA is configured
+func updateHubCABundle(ctx context.Context, c client.Client, hubCA HubCA, caBundle string) error {
+	if !hubCA.enabled() {
+		return deleteHubCABundle(ctx, c)
+	}
+	mtlsCA := &corev1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: mtlsCaName, Namespace: namespace}, mtlsCA)
+	if err != nil {
+		log.Error(err, "Failed to get the hub CA secret", "name", mtlsCaName)
+		return err
+	}
+	data := map[string][]byte{
+		hubCABundleKey: []byte(combineCABundles(string(mtlsCA.Data[mtlsCaKey]), caBundle)),
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hubCABundleName,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		Data: data,
+	}
+	found := &corev1.Secret{}
+	err = c.Get(ctx, types.NamespacedName{Name: hubCABundleName, Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			err = c.Create(ctx, secret)
+			if err != nil {
+				log.Error(err, "Failed to create the hub CA bundle secret", "name", hubCABundleName)
+				return err
+			}
+			log.Info("hub CA bundle secret created", "name", hubCABundleName)
+			return nil
+		}
+		log.Error(err, "Failed to check the hub CA bundle secret", "name", hubCABundleName)
+		return err
+	}
+	if reflect.DeepEqual(found.Data, data) {
+		return nil
+	}
+	secret.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+	err = c.Update(ctx, secret)
+	if err != nil {
+		log.Error(err, "Failed to update the hub CA bundle secret", "name", hubCABundleName)
+		return err
+	}
+	log.Info("hub CA bundle secret updated", "name", hubCABundleName)
+	return nil
+}
+
+// deleteHubCABundle deletes the secret combining the hub CA with the additional CA bundles
+func deleteHubCABundle(ctx context.Context, c client.Client) error {
+	return deleteIfExists(ctx, c, types.NamespacedName{Name: hubCABundleName, Namespace: namespace},
+		&corev1.Secret{})
+}
+
+// apply mounts the combined hub CA bundle in place of the hub CA in the metrics collector
+func (h HubCA) apply(spec *corev1.PodSpec) {
+	if !h.enabled() {
+		return
+	}
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == mtlsCaVolName {
+			spec.Volumes[i].VolumeSource = corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: hubCABundleName,
+				},
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+func newTestCA(t *testing.T, name string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate the key: (%v)", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create the certificate: (%v)", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCombineCABundles(t *testing.T) {
+	hubCA := newTestCA(t, "hub-ca")
+	customCA := newTestCA(t, "custom-ca")
+	bundle := combineCABundles(hubCA, "not a certificate", customCA+hubCA, "")
+	if bundle != hubCA+customCA {
+		t.Fatalf("Wrong combined CA bundle: (%s)", bundle)
+	}
+	if combineCABundles("", "not a certificate") != "" {
+		t.Fatalf("CA bundle combined without certificates")
+	}
+}
+
+func TestHubCABundle(t *testing.T) {
+	ctx := context.TODO()
+	hubCA := newTestCA(t, "hub-ca")
+	customCA := newTestCA(t, "custom-ca")
+	trustedCA := newTestCA(t, "trusted-ca")
+	config := HubCA{
+		CABundle:              &KeyRef{Name: "custom-ca-bundle", Key: "ca-bundle.crt"},
+		InjectTrustedCABundle: true,
+	}
+	c := fake.NewFakeClient(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: mtlsCaName, Namespace: namespace},
+			Data:       map[string][]byte{mtlsCaKey: []byte(hubCA)},
+		},
+	)
+
+	// the configmap of hubCA.caBundle is required
+	if _, err := getHubCABundle(ctx, c, config); err == nil {
+		t.Fatalf("Missed the error for the missing hub CA bundle configmap")
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "custom-ca-bundle", Namespace: namespace},
+		Data:       map[string]string{"ca-bundle.crt": "not a certificate"},
+	}
+	if err := c.Create(ctx, cm); err != nil {
+		t.Fatalf("Failed to create the hub CA bundle configmap: (%v)", err)
+	}
+	if _, err := getHubCABundle(ctx, c, config); err == nil {
+		t.Fatalf("Missed the error for the hub CA bundle without certificates")
+	}
+	cm.Data["ca-bundle.crt"] = customCA
+	if err := c.Update(ctx, cm); err != nil {
+		t.Fatalf("Failed to update the hub CA bundle configmap: (%v)", err)
+	}
+
+	// the trusted CA bundle is not injected yet
+	caBundle, err := getHubCABundle(ctx, c, config)
+	if err != nil || caBundle != customCA {
+		t.Fatalf("Wrong hub CA bundle: (%s), (%v)", caBundle, err)
+	}
+	err = c.Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: trustedCABundleName, Namespace: namespace},
+		Data:       map[string]string{trustedCABundleKey: trustedCA + customCA},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the trusted CA bundle configmap: (%v)", err)
+	}
+	caBundle, err = getHubCABundle(ctx, c, config)
+	if err != nil || caBundle != customCA+trustedCA {
+		t.Fatalf("Wrong hub CA bundle with the trusted CA bundle: (%s), (%v)", caBundle, err)
+	}
+
+	err = updateHubCABundle(ctx, c, config, caBundle)
+	if err != nil {
+		t.Fatalf("Failed to create the hub CA bundle secret: (%v)", err)
+	}
+	secret := &corev1.Secret{}
+	err = c.Get(ctx, types.NamespacedName{Name: hubCABundleName, Namespace: namespace}, secret)
+	if err != nil {
+		t.Fatalf("Failed to get the hub CA bundle secret: (%v)", err)
+	}
+	if string(secret.Data[hubCABundleKey]) != hubCA+customCA+trustedCA {
+		t.Fatalf("Wrong hub CA bundle secret: (%s)", secret.Data[hubCABundleKey])
+	}
+	err = updateHubCABundle(ctx, c, config, customCA)
+	if err != nil {
+		t.Fatalf("Failed to update the hub CA bundle secret: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: hubCABundleName, Namespace: namespace}, secret)
+	if err != nil || string(secret.Data[hubCABundleKey]) != hubCA+customCA {
+		t.Fatalf("The hub CA bundle secret is not updated: (%s), (%v)", secret.Data[hubCABundleKey], err)
+	}
+
+	// the combined bundle is mounted in place of the hub CA
+	spec := createDeployment(testClusterID, "", oashared.ObservabilityAddonSpec{}, HubInfo{},
+		EndpointConfig{HubCA: config}, "", 1).Spec.Template.Spec
+	mounted := false
+	for _, v := range spec.Volumes {
+		mounted = mounted || (v.Name == mtlsCaVolName && v.Secret != nil && v.Secret.SecretName == hubCABundleName)
+	}
+	if !mounted {
+		t.Fatalf("The hub CA bundle is not mounted: (%v)", spec.Volumes)
+	}
+
+	// the router CA of the hub alertmanager is combined with the bundle
+	err = createHubAmRouterCASecret(ctx, &HubInfo{AlertmanagerRouterCA: hubCA}, customCA, promNamespace, c)
+	if err != nil {
+		t.Fatalf("Failed to create the hub-alertmanager-router-ca secret: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: hubAmRouterCASecretName, Namespace: promNamespace}, secret)
+	if err != nil || string(secret.Data[hubAmRouterCASecretKey]) != hubCA+customCA {
+		t.Fatalf("Wrong hub-alertmanager-router-ca secret: (%s), (%v)", secret.Data[hubAmRouterCASecretKey], err)
+	}
+
+	err = updateHubCABundle(ctx, c, HubCA{}, "")
+	if err != nil {
+		t.Fatalf("Failed to delete the hub CA bundle secret: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: hubCABundleName, Namespace: namespace}, &corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("The hub CA bundle secret is not deleted")
+	}
+	_, err = getEndpointConfig(ctx, fake.NewFakeClient(newEndpointConfigCM("hubCA:\n  caBundle:\n    name: ca\n")))
+	if err == nil || !strings.Contains(err.Error(), "hubCA.caBundle") {
+		t.Fatalf("Missed the error for hubCA.caBundle without the key: (%v)", err)
+	}
+}
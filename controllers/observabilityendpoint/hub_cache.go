@@ -0,0 +1,113 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
+)
+
+const (
+	hubCacheName = "observability-addon-hub-cache"
+	hubCacheKey  = "observabilityaddon.json"
+)
+
+// saveHubObsAddon persists the spec and finalizers of the hub observabilityaddon in a local configmap,
+// so that the reconcile can go on with the snapshot while the hub cluster is unreachable
+func saveHubObsAddon(ctx context.Context, c client.Client, hubObsAddon *oav1beta1.ObservabilityAddon) error {
+	snapshot := &oav1beta1.ObservabilityAddon{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       hubObsAddon.Name,
+			Namespace:  hubObsAddon.Namespace,
+			UID:        hubObsAddon.UID,
+			Finalizers: hubObsAddon.Finalizers,
+		},
+		Spec: hubObsAddon.Spec,
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Error(err, "Failed to marshal the hub observabilityaddon")
+		return err
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hubCacheName,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		Data: map[string]string{hubCacheKey: string(data)},
+	}
+
+	found := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: hubCacheName, Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			err = c.Create(ctx, cm)
+			if err != nil {
+				log.Error(err, "Failed to create the hub cache configmap")
+			}
+			return err
+		}
+		log.Error(err, "Failed to check the hub cache configmap")
+		return err
+	}
+	if found.Data[hubCacheKey] != string(data) {
+		cm.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+		err = c.Update(ctx, cm)
+		if err != nil {
+			log.Error(err, "Failed to update the hub cache configmap")
+			return err
+		}
+		log.Info("Updated the hub cache configmap")
+	}
+	return nil
+}
+
+// loadHubObsAddon returns the last saved hub observabilityaddon, or nil if it was never saved
+func loadHubObsAddon(ctx context.Context, c client.Client) (*oav1beta1.ObservabilityAddon, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: hubCacheName, Namespace: namespace}, cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		log.Error(err, "Failed to get the hub cache configmap")
+		return nil, err
+	}
+	hubObsAddon := &oav1beta1.ObservabilityAddon{}
+	err = json.Unmarshal([]byte(cm.Data[hubCacheKey]), hubObsAddon)
+	if err != nil {
+		log.Error(err, "Failed to unmarshal the hub observabilityaddon in the hub cache configmap")
+		return nil, err
+	}
+	return hubObsAddon, nil
+}
+
+func deleteHubCache(ctx context.Context, c client.Client) error {
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: hubCacheName, Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		log.Error(err, "Failed to check the hub cache configmap")
+		return err
+	}
+	err = c.Delete(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to delete the hub cache configmap")
+		return err
+	}
+	log.Info("hub cache configmap deleted")
+	return nil
+}
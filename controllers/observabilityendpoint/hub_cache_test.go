@@ -0,0 +1,57 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestHubCache(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+
+	cached, err := loadHubObsAddon(ctx, c)
+	if err != nil || cached != nil {
+		t.Fatalf("Unexpected hub observabilityaddon before it is saved: (%v, %v)", cached, err)
+	}
+
+	hubObsAddon := newObservabilityAddon(name, testHubNamspace)
+	hubObsAddon.Finalizers = []string{obsAddonFinalizer}
+	hubObsAddon.ResourceVersion = "10"
+	err = saveHubObsAddon(ctx, c, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to save hub observabilityaddon: (%v)", err)
+	}
+	// save again with the changed spec
+	hubObsAddon.Spec.Interval = 300
+	err = saveHubObsAddon(ctx, c, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to update hub observabilityaddon: (%v)", err)
+	}
+
+	cached, err = loadHubObsAddon(ctx, c)
+	if err != nil || cached == nil {
+		t.Fatalf("Failed to load hub observabilityaddon: (%v)", err)
+	}
+	if !reflect.DeepEqual(cached.Spec, hubObsAddon.Spec) ||
+		!reflect.DeepEqual(cached.Finalizers, hubObsAddon.Finalizers) ||
+		cached.Name != hubObsAddon.Name || cached.Namespace != hubObsAddon.Namespace {
+		t.Fatalf("Wrong hub observabilityaddon loaded, expected: %v, got: %v", hubObsAddon, cached)
+	}
+	if cached.ResourceVersion != "" {
+		t.Fatalf("Resource version should not be saved: (%s)", cached.ResourceVersion)
+	}
+
+	err = deleteHubCache(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to delete hub cache: (%v)", err)
+	}
+	cached, err = loadHubObsAddon(ctx, c)
+	if err != nil || cached != nil {
+		t.Fatalf("Hub observabilityaddon not deleted: (%v, %v)", cached, err)
+	}
+}
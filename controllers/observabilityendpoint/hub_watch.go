@@ -0,0 +1,137 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/stolostron/endpoint-metrics-operator/pkg/util"
+	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
+)
+
+const (
+	hubWatchRetryInterval = 30 * time.Second
+	hubWatchEventBuffer   = 10
+)
+
+// hubWatcher watches the observabilityaddon in the hub cluster, and sends an event for the local
+// observabilityaddon when the spec, finalizers or deletionTimestamp is changed in the hub.
+// The watch is restarted when the hub kubeconfig is rotated.
+type hubWatcher struct {
+	hubProvider   *util.HubClientProvider
+	scheme        *runtime.Scheme
+	retryInterval time.Duration
+	events        chan event.GenericEvent
+}
+
+func newHubWatcher(hubProvider *util.HubClientProvider, scheme *runtime.Scheme) *hubWatcher {
+	return &hubWatcher{
+		hubProvider:   hubProvider,
+		scheme:        scheme,
+		retryInterval: hubWatchRetryInterval,
+		events:        make(chan event.GenericEvent, hubWatchEventBuffer),
+	}
+}
+
+// Start runs the watch until the context is done, it implements manager.Runnable
+func (w *hubWatcher) Start(ctx context.Context) error {
+	reloaded := w.hubProvider.Subscribe()
+	for {
+		watchCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- w.watch(watchCtx)
+		}()
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			return nil
+		case <-reloaded:
+			log.Info("Hub kubeconfig is rotated, restart the watch for the hub observabilityaddon")
+		case err := <-done:
+			log.Error(err, "Failed to watch the observabilityaddon in hub cluster, will retry")
+			select {
+			case <-ctx.Done():
+				cancel()
+				return nil
+			case <-reloaded:
+			case <-time.After(w.retryInterval):
+			}
+		}
+		cancel()
+	}
+}
+
+// NeedLeaderElection returns false so that the events are not lost before the leader is elected
+func (w *hubWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *hubWatcher) watch(ctx context.Context) error {
+	hubCache, err := cache.New(w.hubProvider.Config(), cache.Options{Scheme: w.scheme, Namespace: hubNamespace})
+	if err != nil {
+		return err
+	}
+	informer, err := hubCache.GetInformer(ctx, &oav1beta1.ObservabilityAddon{})
+	if err != nil {
+		return err
+	}
+	informer.AddEventHandler(w.eventHandler())
+	log.Info("Start to watch the observabilityaddon in hub cluster", "namespace", hubNamespace)
+	return hubCache.Start(ctx)
+}
+
+func (w *hubWatcher) eventHandler() toolscache.ResourceEventHandler {
+	return toolscache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			o, ok := obj.(*oav1beta1.ObservabilityAddon)
+			return ok && o.Name == obAddonName
+		},
+		Handler: toolscache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.enqueue()
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if hubObsAddonChanged(oldObj.(*oav1beta1.ObservabilityAddon), newObj.(*oav1beta1.ObservabilityAddon)) {
+					w.enqueue()
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				w.enqueue()
+			},
+		},
+	}
+}
+
+// enqueue sends the event for the local observabilityaddon, the event is dropped if
+// there are already events pending since they trigger the same reconcile
+func (w *hubWatcher) enqueue() {
+	e := event.GenericEvent{
+		Object: &oav1beta1.ObservabilityAddon{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      obAddonName,
+				Namespace: namespace,
+			},
+		},
+	}
+	select {
+	case w.events <- e:
+	default:
+	}
+}
+
+// hubObsAddonChanged returns true if the change of the hub observabilityaddon needs to be reconciled
+func hubObsAddonChanged(oldObj, newObj *oav1beta1.ObservabilityAddon) bool {
+	return !reflect.DeepEqual(oldObj.Spec, newObj.Spec) ||
+		!reflect.DeepEqual(oldObj.Finalizers, newObj.Finalizers) ||
+		!reflect.DeepEqual(oldObj.DeletionTimestamp, newObj.DeletionTimestamp)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
+)
+
+func TestHubObsAddonChanged(t *testing.T) {
+	now := metav1.Now()
+	caseList := []struct {
+		caseName string
+		update   func(*oav1beta1.ObservabilityAddon)
+		expected bool
+	}{
+		{"no change", func(o *oav1beta1.ObservabilityAddon) {}, false},
+		{"status change", func(o *oav1beta1.ObservabilityAddon) { o.Status.Conditions = nil; o.ResourceVersion = "2" }, false},
+		{"spec change", func(o *oav1beta1.ObservabilityAddon) { o.Spec.EnableMetrics = false }, true},
+		{"finalizer change", func(o *oav1beta1.ObservabilityAddon) { o.Finalizers = []string{obsAddonFinalizer} }, true},
+		{"deletion", func(o *oav1beta1.ObservabilityAddon) { o.DeletionTimestamp = &now }, true},
+	}
+	for _, c := range caseList {
+		oldObj := newObservabilityAddon(name, testHubNamspace)
+		newObj := oldObj.DeepCopy()
+		c.update(newObj)
+		if hubObsAddonChanged(oldObj, newObj) != c.expected {
+			t.Errorf("Wrong result in case: %s, expected: %v", c.caseName, c.expected)
+		}
+	}
+}
+
+func TestHubWatcherEventHandler(t *testing.T) {
+	w := newHubWatcher(nil, scheme.Scheme)
+	h := w.eventHandler()
+
+	oba := newObservabilityAddon(name, testHubNamspace)
+	h.OnAdd(oba)
+	e := <-w.events
+	if e.Object.GetName() != obAddonName || e.Object.GetNamespace() != namespace {
+		t.Fatalf("Wrong object enqueued: %s/%s", e.Object.GetNamespace(), e.Object.GetName())
+	}
+
+	h.OnUpdate(oba, oba.DeepCopy())
+	other := newObservabilityAddon("other-addon", testHubNamspace)
+	h.OnAdd(other)
+	if len(w.events) != 0 {
+		t.Fatalf("Unexpected events enqueued: %d", len(w.events))
+	}
+
+	// the events are dropped if the buffer is full
+	for i := 0; i < hubWatchEventBuffer+1; i++ {
+		h.OnDelete(oba)
+	}
+	if len(w.events) != hubWatchEventBuffer {
+		t.Fatalf("Wrong number of events enqueued: %d", len(w.events))
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	ocinfrav1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	platformAuto       = "auto"
+	platformOpenShift  = "openshift"
+	platformKubernetes = "kubernetes"
+)
+
+const (
+	promOperatorSvcLabelKey   = "operated-prometheus"
+	promOperatorSvcLabelValue = "true"
+	promOperatorPortName      = "web"
+	kubeRootCAName            = "kube-root-ca.crt"
+	kubeRootCAKey             = "ca.crt"
+	kubeSystemNamespace       = "kube-system"
+)
+
+// detectPlatform returns the configured platform, or detects it if it is not configured.
+// The cluster is treated as OpenShift if the ClusterVersion or the OCP prometheus service exists.
+func detectPlatform(ctx context.Context, c client.Client, configured string) (string, error) {
+	if configured == platformOpenShift || configured == platformKubernetes {
+		return configured, nil
+	}
+	clusterVersion := &ocinfrav1.ClusterVersion{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "version"}, clusterVersion); err == nil {
+		return platformOpenShift, nil
+	}
+	// OCP 3.11 has no ClusterVersion
+	promSvc := &corev1.Service{}
+	err := c.Get(ctx, types.NamespacedName{Name: promSvcName, Namespace: promNamespace}, promSvc)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return platformKubernetes, nil
+		}
+		log.Error(err, "Failed to check prometheus resource")
+		return "", err
+	}
+	return platformOpenShift, nil
+}
+
+// getPromOperatorURL finds the Prometheus deployed by prometheus-operator in the cluster.
+// It returns false if no Prometheus is found.
+func getPromOperatorURL(ctx context.Context, c client.Client) (string, bool, error) {
+	svcList := &corev1.ServiceList{}
+	err := c.List(ctx, svcList, client.MatchingLabels{promOperatorSvcLabelKey: promOperatorSvcLabelValue})
+	if err != nil {
+		log.Error(err, "Failed to list the prometheus-operator services")
+		return "", false, err
+	}
+	if len(svcList.Items) == 0 {
+		return "", false, nil
+	}
+	sort.Slice(svcList.Items, func(i, j int) bool {
+		if svcList.Items[i].Namespace != svcList.Items[j].Namespace {
+			return svcList.Items[i].Namespace < svcList.Items[j].Namespace
+		}
+		return svcList.Items[i].Name < svcList.Items[j].Name
+	})
+	svc := svcList.Items[0]
+	port := int32(9090)
+	if len(svc.Spec.Ports) > 0 {
+		port = svc.Spec.Ports[0].Port
+	}
+	for _, p := range svc.Spec.Ports {
+		if p.Name == promOperatorPortName {
+			port = p.Port
+			break
+		}
+	}
+	return fmt.Sprintf("http://%s.%s.svc:%d", svc.Name, svc.Namespace, port), true, nil
+}
+
+// getKubeClusterID uses the uid of the kube-system namespace as the cluster id
+func getKubeClusterID(ctx context.Context, c client.Client) (string, error) {
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, types.NamespacedName{Name: kubeSystemNamespace}, ns); err != nil {
+		log.Error(err, "Failed to get namespace", "name", kubeSystemNamespace)
+		return "", err
+	}
+	return string(ns.UID), nil
+}
+
+// createKubeCAConfigmap creates the CA configmap from the kube-root-ca.crt configmap,
+// since the service-ca injection is not available in the non-OpenShift clusters
+func createKubeCAConfigmap(ctx context.Context, c client.Client) error {
+	rootCA := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: kubeRootCAName, Namespace: namespace}, rootCA)
+	if err != nil {
+		log.Error(err, "Failed to get the configmap", "name", kubeRootCAName)
+		return err
+	}
+	data := map[string]string{"service-ca.crt": rootCA.Data[kubeRootCAKey]}
+
+	found := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: caConfigmapName,
+		Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      caConfigmapName,
+					Namespace: namespace,
+					Annotations: map[string]string{
+						ownerLabelKey: ownerLabelValue,
+					},
+				},
+				Data: data,
+			}
+			err = c.Create(ctx, cm)
+			if err == nil {
+				log.Info("Configmap created")
+			} else {
+				log.Error(err, "Failed to create the configmap")
+			}
+			return err
+		}
+		log.Error(err, "Failed to check the configmap")
+		return err
+	}
+	if !reflect.DeepEqual(found.Data, data) {
+		found.Data = data
+		err = c.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "Failed to update the configmap")
+			return err
+		}
+		log.Info("Configmap updated")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	testKubeSystemUID = "kube-system-uid"
+)
+
+func newPromOperatorSvc(name string, ns string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels: map[string]string{
+				promOperatorSvcLabelKey: promOperatorSvcLabelValue,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "grpc", Port: 10901},
+				{Name: promOperatorPortName, Port: 9090},
+			},
+		},
+	}
+}
+
+func newKubeSystemNamespace() *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: kubeSystemNamespace,
+			UID:  testKubeSystemUID,
+		},
+	}
+}
+
+func newKubeRootCA(ca string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kubeRootCAName,
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			kubeRootCAKey: ca,
+		},
+	}
+}
+
+func TestDetectPlatform(t *testing.T) {
+	ctx := context.TODO()
+	caseList := []struct {
+		caseName   string
+		configured string
+		c          *fake.ClientBuilder
+		expected   string
+	}{
+		{"configured openshift", platformOpenShift, fake.NewClientBuilder(), platformOpenShift},
+		{"configured kubernetes", platformKubernetes, fake.NewClientBuilder().WithObjects(cv), platformKubernetes},
+		{"ocp 4.x", platformAuto, fake.NewClientBuilder().WithObjects(cv), platformOpenShift},
+		{"ocp 3.11", "", fake.NewClientBuilder().WithObjects(newPromSvc()), platformOpenShift},
+		{"kubernetes", "", fake.NewClientBuilder(), platformKubernetes},
+	}
+	for _, c := range caseList {
+		t.Run(c.caseName, func(t *testing.T) {
+			platform, err := detectPlatform(ctx, c.c.Build(), c.configured)
+			if err != nil {
+				t.Fatalf("Failed to detect platform: (%v)", err)
+			}
+			if platform != c.expected {
+				t.Fatalf("Wrong platform detected, expected: %s, got: %s", c.expected, platform)
+			}
+		})
+	}
+}
+
+func TestGetPromOperatorURL(t *testing.T) {
+	ctx := context.TODO()
+	_, found, err := getPromOperatorURL(ctx, fake.NewFakeClient())
+	if err != nil || found {
+		t.Fatalf("Prometheus should not be found: (%v)", err)
+	}
+	c := fake.NewFakeClient(newPromOperatorSvc("prometheus-operated", "monitoring"),
+		newPromOperatorSvc("prometheus-operated", "z-monitoring"))
+	url, found, err := getPromOperatorURL(ctx, c)
+	if err != nil || !found {
+		t.Fatalf("Failed to find prometheus: (%v)", err)
+	}
+	if url != "http://prometheus-operated.monitoring.svc:9090" {
+		t.Fatalf("Wrong prometheus url: (%s)", url)
+	}
+}
+
+func TestGetKubeClusterID(t *testing.T) {
+	clusterID, err := getKubeClusterID(context.TODO(), fake.NewFakeClient(newKubeSystemNamespace()))
+	if err != nil {
+		t.Fatalf("Failed to get cluster id: (%v)", err)
+	}
+	if clusterID != testKubeSystemUID {
+		t.Fatalf("Wrong cluster id: (%s)", clusterID)
+	}
+}
+
+func TestCreateKubeCAConfigmap(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient(newKubeRootCA("test-ca"))
+	err := createKubeCAConfigmap(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to create CA configmap: (%v)", err)
+	}
+	rootCA := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: kubeRootCAName, Namespace: namespace}, rootCA)
+	if err != nil {
+		t.Fatalf("Failed to get kube root CA configmap: (%v)", err)
+	}
+	rootCA.Data[kubeRootCAKey] = "test-ca-rotated"
+	err = c.Update(ctx, rootCA)
+	if err != nil {
+		t.Fatalf("Failed to update kube root CA configmap: (%v)", err)
+	}
+	err = createKubeCAConfigmap(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to update CA configmap: (%v)", err)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: caConfigmapName, Namespace: namespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get CA configmap: (%v)", err)
+	}
+	if cm.Data["service-ca.crt"] != "test-ca-rotated" {
+		t.Fatalf("CA configmap not updated: (%v)", cm.Data)
+	}
+	err = deleteCAConfigmap(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to delete CA configmap: (%v)", err)
+	}
+}
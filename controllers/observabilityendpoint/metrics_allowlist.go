@@ -0,0 +1,324 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const (
+	customMetricsConfigMapName = "observability-metrics-custom-allowlist"
+	allowlistLabelKey          = "observability.open-cluster-management.io/metrics-allowlist"
+	allowlistLabelValue        = "true"
+)
+
+// isAllowlistConfigMap checks if the configmap contributes to the metrics allowlist
+func isAllowlistConfigMap(name string, labels map[string]string) bool {
+	if name == metricsConfigMapName || name == customMetricsConfigMapName {
+		return true
+	}
+	return labels[allowlistLabelKey] == allowlistLabelValue
+}
+
+// getAllowlistConfigMaps returns all the configmaps contributing to the metrics allowlist.
+// The default allowlist comes first, then the custom allowlist, then the labeled ones sorted by name.
+// The missing configmaps are skipped, the other errors are returned so that the last known good allowlist is kept.
+func getAllowlistConfigMaps(ctx context.Context, c client.Client) ([]corev1.ConfigMap, error) {
+	cms := []corev1.ConfigMap{}
+	for _, name := range []string{metricsConfigMapName, customMetricsConfigMapName} {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, cm)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				log.Error(err, "Failed to get configmap", "name", name)
+				return nil, err
+			}
+			if name == metricsConfigMapName {
+				log.Error(err, "Failed to get configmap", "name", name)
+			}
+			continue
+		}
+		cms = append(cms, *cm)
+	}
+
+	labeled := &corev1.ConfigMapList{}
+	err := c.List(ctx, labeled, client.InNamespace(namespace),
+		client.MatchingLabels{allowlistLabelKey: allowlistLabelValue})
+	if err != nil {
+		log.Error(err, "Failed to list labeled allowlist configmaps")
+		return nil, err
+	}
+	sort.Slice(labeled.Items, func(i, j int) bool {
+		return labeled.Items[i].Name < labeled.Items[j].Name
+	})
+	for _, cm := range labeled.Items {
+		if cm.Name == metricsConfigMapName || cm.Name == customMetricsConfigMapName {
+			continue
+		}
+		cms = append(cms, cm)
+	}
+	return cms, nil
+}
+
+// NewAppAllowlistCache creates the cache of the labeled allowlist configmaps in all the namespaces and adds it
+// to the manager, the cache of the manager only holds the configmaps in the namespace of the operator
+func NewAppAllowlistCache(mgr ctrl.Manager) (cache.Cache, error) {
+	c, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme: mgr.GetScheme(),
+		Mapper: mgr.GetRESTMapper(),
+		SelectorsByObject: cache.SelectorsByObject{
+			&corev1.ConfigMap{}: {
+				Label: labels.SelectorFromSet(labels.Set{allowlistLabelKey: allowlistLabelValue}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := mgr.Add(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// getAppAllowlistConfigMaps returns the labeled allowlist configmaps in the application namespaces,
+// which are all the namespaces except the one of the operator, sorted by namespace and name
+func getAppAllowlistConfigMaps(ctx context.Context, r client.Reader) ([]corev1.ConfigMap, error) {
+	list := &corev1.ConfigMapList{}
+	err := r.List(ctx, list, client.MatchingLabels{allowlistLabelKey: allowlistLabelValue})
+	if err != nil {
+		log.Error(err, "Failed to list the allowlist configmaps in the application namespaces")
+		return nil, err
+	}
+	cms := []corev1.ConfigMap{}
+	for _, cm := range list.Items {
+		if cm.Namespace != namespace {
+			cms = append(cms, cm)
+		}
+	}
+	sort.Slice(cms, func(i, j int) bool {
+		if cms[i].Namespace != cms[j].Namespace {
+			return cms[i].Namespace < cms[j].Namespace
+		}
+		return cms[i].Name < cms[j].Name
+	})
+	return cms, nil
+}
+
+// mergeAppMetricsAllowlists merges the allowlists in the application namespaces into the userWorkload section
+// of the allowlist, every entry is scoped to the namespace of its configmap.
+// The configmaps with invalid content are skipped, so that they don't block the changes of the other allowlists.
+func mergeAppMetricsAllowlists(ctx context.Context, r client.Reader, l *MetricsAllowlist) error {
+	cms, err := getAppAllowlistConfigMaps(ctx, r)
+	if err != nil {
+		return err
+	}
+	for _, cm := range cms {
+		if cm.Data == nil {
+			continue
+		}
+		list := &MetricsAllowlist{}
+		err := yaml.Unmarshal([]byte(cm.Data[metricsConfigMapKey]), list)
+		if err == nil {
+			err = validateAppMetricsAllowlist(list)
+		}
+		if err != nil {
+			log.Error(err, "Invalid allowlist in configmap", "namespace", cm.Namespace, "name", cm.Name)
+			recordWarning(ctx, eventInvalidAppAllowlist, "Skipped the allowlist in configmap %s/%s: %v",
+				cm.Namespace, cm.Name, err)
+			continue
+		}
+		if l.UserWorkload == nil {
+			l.UserWorkload = &MetricsAllowlist{}
+		}
+		mergeMetricsAllowlist(l.UserWorkload, scopeMetricsAllowlist(list, cm.Namespace))
+	}
+	return nil
+}
+
+// validateAppMetricsAllowlist checks the allowlist in an application namespace, only names and matches are allowed
+// since renames and rules are not limited to the namespace
+func validateAppMetricsAllowlist(l *MetricsAllowlist) error {
+	if len(l.ReNameMap) != 0 {
+		return fmt.Errorf("renames are not allowed in the allowlist of an application namespace")
+	}
+	if len(l.RuleList) != 0 {
+		return fmt.Errorf("rules are not allowed in the allowlist of an application namespace")
+	}
+	if l.UserWorkload != nil {
+		return fmt.Errorf("userWorkload is not allowed in the allowlist of an application namespace")
+	}
+	return validateAllowlistEntries(l)
+}
+
+// scopeMetricsAllowlist converts the names and matches of the allowlist into the matches with
+// the namespace matcher injected, so that only the series in the namespace are collected
+func scopeMetricsAllowlist(l *MetricsAllowlist, ns string) *MetricsAllowlist {
+	nsMatcher := fmt.Sprintf("namespace=%q", ns)
+	scoped := &MetricsAllowlist{}
+	for _, name := range l.NameList {
+		scoped.MatchList = append(scoped.MatchList, fmt.Sprintf("__name__=%q,%s", name, nsMatcher))
+	}
+	for _, match := range l.MatchList {
+		match = strings.TrimSuffix(strings.TrimSpace(match), ",")
+		scoped.MatchList = append(scoped.MatchList, match+","+nsMatcher)
+	}
+	return scoped
+}
+
+// getMetricsAllowlist merges the allowlists from all the allowlist configmaps.
+// The configmaps with invalid content are skipped, and the error for the first one is returned.
+func getMetricsAllowlist(ctx context.Context, client client.Client) (MetricsAllowlist, error) {
+	cms, err := getAllowlistConfigMaps(ctx, client)
+	if err != nil {
+		return MetricsAllowlist{}, err
+	}
+	return mergeAllowlistConfigMaps(cms)
+}
+
+// mergeAllowlistConfigMaps merges the allowlists in the configmaps.
+// The configmaps with invalid content are skipped, and the error for the first one is returned.
+func mergeAllowlistConfigMaps(cms []corev1.ConfigMap) (MetricsAllowlist, error) {
+	l := &MetricsAllowlist{}
+	var invalidErr error
+	for _, cm := range cms {
+		if cm.Data == nil {
+			continue
+		}
+		list := &MetricsAllowlist{}
+		err := yaml.Unmarshal([]byte(cm.Data[metricsConfigMapKey]), list)
+		if err == nil {
+			err = validateMetricsAllowlist(list)
+		}
+		if err != nil {
+			log.Error(err, "Invalid allowlist in configmap", "name", cm.Name)
+			if invalidErr == nil {
+				invalidErr = fmt.Errorf("invalid allowlist in configmap %s: %v", cm.Name, err)
+			}
+			continue
+		}
+		mergeMetricsAllowlist(l, list)
+	}
+	return *l, invalidErr
+}
+
+// validateMetricsAllowlist checks the syntax of all the entries in the allowlist
+func validateMetricsAllowlist(l *MetricsAllowlist) error {
+	if err := validateAllowlistEntries(l); err != nil {
+		return err
+	}
+	if l.UserWorkload != nil {
+		if l.UserWorkload.UserWorkload != nil {
+			return fmt.Errorf("userWorkload cannot be nested in userWorkload")
+		}
+		if err := validateAllowlistEntries(l.UserWorkload); err != nil {
+			return fmt.Errorf("invalid userWorkload: %v", err)
+		}
+	}
+	return nil
+}
+
+func validateAllowlistEntries(l *MetricsAllowlist) error {
+	names := map[string]bool{}
+	for _, name := range l.NameList {
+		if name == "" {
+			return fmt.Errorf("empty metric name in names")
+		}
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			return fmt.Errorf("invalid metric name %q in names", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicated metric name %q in names", name)
+		}
+		names[name] = true
+	}
+	for _, match := range l.MatchList {
+		if strings.TrimSpace(match) == "" {
+			return fmt.Errorf("empty matcher in matches")
+		}
+		if _, err := parser.ParseMetricSelector("{" + match + "}"); err != nil {
+			return fmt.Errorf("invalid matcher %q in matches: %v", match, err)
+		}
+	}
+	for k, v := range l.ReNameMap {
+		if !model.IsValidMetricName(model.LabelValue(k)) {
+			return fmt.Errorf("invalid metric name %q in renames", k)
+		}
+		if !model.IsValidMetricName(model.LabelValue(v)) {
+			return fmt.Errorf("invalid new name %q for metric %q in renames", v, k)
+		}
+	}
+	records := map[string]bool{}
+	for _, rule := range l.RuleList {
+		if rule.Record == "" {
+			return fmt.Errorf("empty record name in rules")
+		}
+		if !model.IsValidMetricName(model.LabelValue(rule.Record)) {
+			return fmt.Errorf("invalid record name %q in rules", rule.Record)
+		}
+		if records[rule.Record] {
+			return fmt.Errorf("duplicated record name %q in rules", rule.Record)
+		}
+		records[rule.Record] = true
+		if _, err := parser.ParseExpr(rule.Expr); err != nil {
+			return fmt.Errorf("invalid expr %q for record %q in rules: %v", rule.Expr, rule.Record, err)
+		}
+	}
+	return nil
+}
+
+// mergeMetricsAllowlist merges the entries of src into dst, skipping duplicated entries.
+// For renames and rules the entries already in dst take precedence.
+func mergeMetricsAllowlist(dst *MetricsAllowlist, src *MetricsAllowlist) {
+	if src.UserWorkload != nil {
+		if dst.UserWorkload == nil {
+			dst.UserWorkload = &MetricsAllowlist{}
+		}
+		mergeMetricsAllowlist(dst.UserWorkload, src.UserWorkload)
+	}
+	for _, name := range src.NameList {
+		if !contains(dst.NameList, name) {
+			dst.NameList = append(dst.NameList, name)
+		}
+	}
+	for _, match := range src.MatchList {
+		if !contains(dst.MatchList, match) {
+			dst.MatchList = append(dst.MatchList, match)
+		}
+	}
+	for k, v := range src.ReNameMap {
+		if dst.ReNameMap == nil {
+			dst.ReNameMap = map[string]string{}
+		}
+		if _, ok := dst.ReNameMap[k]; !ok {
+			dst.ReNameMap[k] = v
+		}
+	}
+	for _, rule := range src.RuleList {
+		exists := false
+		for _, r := range dst.RuleList {
+			if r.Record == rule.Record {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			dst.RuleList = append(dst.RuleList, rule)
+		}
+	}
+}
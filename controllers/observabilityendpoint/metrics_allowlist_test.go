@@ -0,0 +1,224 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newAllowlistCM(name string, labels map[string]string, data string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Data: map[string]string{
+			metricsConfigMapKey: data,
+		},
+	}
+}
+
+func TestGetMetricsAllowlist(t *testing.T) {
+	customCM := newAllowlistCM(customMetricsConfigMapName, nil, `
+names:
+  - b
+  - d
+renames:
+  e: e1
+rules:
+  - record: f
+    expr: h
+`)
+	labeledCM := newAllowlistCM("app-allowlist", map[string]string{allowlistLabelKey: allowlistLabelValue}, `
+names:
+  - x
+matches:
+  - __name__="c"
+  - __name__="y"
+renames:
+  e: e2
+rules:
+  - record: z
+    expr: sum(x)
+`)
+	unlabeledCM := newAllowlistCM("other-configmap", nil, `
+names:
+  - should-not-be-included
+`)
+
+	c := fake.NewFakeClient(getAllowlistCM(), customCM, labeledCM, unlabeledCM)
+	list, err := getMetricsAllowlist(context.TODO(), c)
+	if err != nil {
+		t.Fatalf("Failed to get metrics allowlist: (%v)", err)
+	}
+
+	expected := MetricsAllowlist{
+		NameList:  []string{"a", "b", "d", "x"},
+		MatchList: []string{`__name__="c"`, `__name__="y"`},
+		ReNameMap: map[string]string{"e": "e1"},
+		RuleList: []Rule{
+			{Record: "f", Expr: "g"},
+			{Record: "z", Expr: "sum(x)"},
+		},
+	}
+	if !reflect.DeepEqual(list, expected) {
+		t.Fatalf("Wrong merged allowlist, expected: %v, got: %v", expected, list)
+	}
+}
+
+func TestGetUserWorkloadMetricsAllowlist(t *testing.T) {
+	customCM := newAllowlistCM(customMetricsConfigMapName, nil, `
+userWorkload:
+  names:
+    - app_requests_total
+`)
+	labeledCM := newAllowlistCM("app-allowlist", map[string]string{allowlistLabelKey: allowlistLabelValue}, `
+userWorkload:
+  names:
+    - app_requests_total
+  matches:
+    - __name__="app_errors_total"
+`)
+
+	c := fake.NewFakeClient(getAllowlistCM(), customCM, labeledCM)
+	list, err := getMetricsAllowlist(context.TODO(), c)
+	if err != nil {
+		t.Fatalf("Failed to get metrics allowlist: (%v)", err)
+	}
+
+	expected := &MetricsAllowlist{
+		NameList:  []string{"app_requests_total"},
+		MatchList: []string{`__name__="app_errors_total"`},
+	}
+	if !reflect.DeepEqual(list.UserWorkload, expected) {
+		t.Fatalf("Wrong merged userWorkload allowlist, expected: %v, got: %v", expected, list.UserWorkload)
+	}
+}
+
+func TestMergeAppMetricsAllowlists(t *testing.T) {
+	labels := map[string]string{allowlistLabelKey: allowlistLabelValue}
+	operatorCM := newAllowlistCM("app-allowlist", labels, "names:\n  - in_operator_namespace\n")
+	appCM := newAllowlistCM("app-allowlist", labels, `
+names:
+  - app_requests_total
+matches:
+  - __name__="app_errors_total",code=~"5.."
+`)
+	appCM.Namespace = "team-a"
+	invalidCM := newAllowlistCM("app-allowlist", labels, `
+names:
+  - app_requests_total
+rules:
+  - record: all_requests
+    expr: sum(app_requests_total)
+`)
+	invalidCM.Namespace = "team-b"
+
+	recorder := record.NewFakeRecorder(10)
+	ctx := withEvents(context.TODO(), recorder, newObservabilityAddon(name, testNamespace))
+	c := fake.NewFakeClient(operatorCM, appCM, invalidCM)
+	l := MetricsAllowlist{NameList: []string{"up"}}
+	err := mergeAppMetricsAllowlists(ctx, c, &l)
+	if err != nil {
+		t.Fatalf("Failed to merge the application allowlists: (%v)", err)
+	}
+
+	expected := MetricsAllowlist{
+		NameList: []string{"up"},
+		UserWorkload: &MetricsAllowlist{
+			MatchList: []string{
+				`__name__="app_requests_total",namespace="team-a"`,
+				`__name__="app_errors_total",code=~"5..",namespace="team-a"`,
+			},
+		},
+	}
+	if !reflect.DeepEqual(l, expected) {
+		t.Fatalf("Wrong merged allowlist, expected: %v, got: %v", expected, l)
+	}
+	if !hasEvent(drainEvents(recorder), "Warning", eventInvalidAppAllowlist) {
+		t.Fatalf("Missed the event for the invalid application allowlist")
+	}
+}
+
+func TestIsAllowlistConfigMap(t *testing.T) {
+	caseList := []struct {
+		name     string
+		labels   map[string]string
+		expected bool
+	}{
+		{metricsConfigMapName, nil, true},
+		{customMetricsConfigMapName, nil, true},
+		{"app-allowlist", map[string]string{allowlistLabelKey: allowlistLabelValue}, true},
+		{"app-allowlist", map[string]string{allowlistLabelKey: "false"}, false},
+		{"app-allowlist", nil, false},
+	}
+	for _, c := range caseList {
+		if isAllowlistConfigMap(c.name, c.labels) != c.expected {
+			t.Errorf("Wrong result for configmap %s with labels %v, expected %v", c.name, c.labels, c.expected)
+		}
+	}
+}
+
+func TestValidateMetricsAllowlist(t *testing.T) {
+	caseList := []struct {
+		caseName string
+		data     string
+		valid    bool
+	}{
+		{
+			caseName: "valid allowlist",
+			data: `
+names:
+  - up
+matches:
+  - __name__="kube_pod_info",namespace=~"openshift-.*"
+renames:
+  mixin_pod_workload: namespace_workload_pod:kube_pod_owner:relabel
+rules:
+  - record: apiserver_request_duration_seconds:histogram_quantile_99
+    expr: histogram_quantile(0.99,sum(rate(apiserver_request_duration_seconds_bucket{job="apiserver"}[5m])) by (le))
+`,
+			valid: true,
+		},
+		{caseName: "empty name", data: "names:\n  - \"\"\n"},
+		{caseName: "duplicated name", data: "names:\n  - up\n  - up\n"},
+		{caseName: "invalid name", data: "names:\n  - up-1\n"},
+		{caseName: "invalid matcher", data: "matches:\n  - __name__=up\n"},
+		{caseName: "invalid rename", data: "renames:\n  up: up-1\n"},
+		{caseName: "duplicated record", data: "rules:\n  - record: a\n    expr: up\n  - record: a\n    expr: up\n"},
+		{caseName: "invalid expr", data: "rules:\n  - record: a\n    expr: sum(up\n"},
+		{caseName: "valid userWorkload", data: "userWorkload:\n  names:\n    - app_requests_total\n", valid: true},
+		{caseName: "invalid userWorkload name", data: "userWorkload:\n  names:\n    - app-1\n"},
+		{caseName: "nested userWorkload", data: "userWorkload:\n  userWorkload:\n    names:\n      - up\n"},
+	}
+	for _, c := range caseList {
+		t.Run(c.caseName, func(t *testing.T) {
+			client := fake.NewFakeClient(newAllowlistCM(metricsConfigMapName, nil, c.data))
+			_, err := getMetricsAllowlist(context.TODO(), client)
+			if c.valid && err != nil {
+				t.Fatalf("Unexpected error for valid allowlist: (%v)", err)
+			}
+			if !c.valid && err == nil {
+				t.Fatalf("Missed the error for invalid allowlist")
+			}
+		})
+	}
+}
+
+func TestGetMetricsAllowlistReadError(t *testing.T) {
+	c := unreachableClient{fake.NewFakeClient(getAllowlistCM())}
+	if _, err := getMetricsAllowlist(context.TODO(), c); err == nil {
+		t.Fatalf("Missed the error when the allowlist configmaps cannot be read")
+	}
+	if cms, err := getAllowlistConfigMaps(context.TODO(), fake.NewFakeClient()); err != nil || len(cms) != 0 {
+		t.Fatalf("The missing allowlist configmaps should be skipped: %v, (%v)", cms, err)
+	}
+}
@@ -8,9 +8,9 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
-	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -18,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/stolostron/endpoint-metrics-operator/pkg/util"
 	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
 )
 
@@ -33,6 +34,8 @@ const (
 	caVolName            = "serving-certs-ca-bundle"
 	mtlsCertName         = "observability-controller-open-cluster-management.io-observability-signer-client-cert"
 	mtlsCaName           = "observability-managed-cluster-certs"
+	mtlsCaKey            = "ca.crt"
+	mtlsCaVolName        = "mtlsca"
 	limitBytes           = 1073741824
 	defaultInterval      = "30s"
 )
@@ -41,19 +44,63 @@ const (
 	kindClusterID   = "kind-cluster-id"
 	kindClusterHost = "observatorium.hub"
 	kindClusterIP   = "172.17.0.2"
+	kindPromURL     = "http://prometheus-k8s.openshift-monitoring.svc:9090"
 	restartLabel    = "cert/time-restarted"
 )
 
+const (
+	sourceCAVolName      = "metrics-source-ca"
+	sourceCAMountPath    = "/etc/metrics-source/ca"
+	sourceTokenVolName   = "metrics-source-token"
+	sourceTokenMountPath = "/etc/metrics-source/token"
+	saTokenFile          = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
 var (
 	collectorImage = os.Getenv("COLLECTOR_IMAGE")
-	ocpPromURL     = "https://prometheus-k8s.openshift-monitoring.svc:9091"
+	// the waiting reasons of the metrics collector container which are treated as Degraded
+	failedWaitingReasons = []string{
+		"CrashLoopBackOff",
+		"ImagePullBackOff",
+		"ErrImagePull",
+		"InvalidImageName",
+		"CreateContainerConfigError",
+		"CreateContainerError",
+	}
 )
 
+// collectorKind identifies a metrics collector deployment and the resources owned by it
+type collectorKind struct {
+	// name is the name of the deployment
+	name string
+	// configName is the name of the configmap containing the collector config
+	configName string
+	// component is the value of the component label of the collector pods
+	component string
+	// serviceAccountName is the service account of the collector pods,
+	// the service account of the operator is used if it is empty
+	serviceAccountName string
+}
+
+var platformCollector = collectorKind{
+	name:       metricsCollectorName,
+	configName: collectorConfigName,
+	component:  selectorValue,
+}
+
+// MetricsAllowlist is the allowlist of the metrics collected from the platform monitoring stack,
+// the metrics from the user workload monitoring stack are listed in UserWorkload
 type MetricsAllowlist struct {
-	NameList  []string          `yaml:"names"`
-	MatchList []string          `yaml:"matches"`
-	ReNameMap map[string]string `yaml:"renames"`
-	RuleList  []Rule            `yaml:"rules"`
+	NameList     []string          `yaml:"names"`
+	MatchList    []string          `yaml:"matches"`
+	ReNameMap    map[string]string `yaml:"renames"`
+	RuleList     []Rule            `yaml:"rules"`
+	UserWorkload *MetricsAllowlist `yaml:"userWorkload,omitempty"`
+}
+
+// isEmpty returns true if no metrics is allowed by the entries of the allowlist
+func (l *MetricsAllowlist) isEmpty() bool {
+	return l == nil || (len(l.NameList) == 0 && len(l.MatchList) == 0 && len(l.RuleList) == 0)
 }
 
 // Rule is the struct for recording rules and alert rules
@@ -71,12 +118,40 @@ type HubInfo struct {
 }
 
 func createDeployment(clusterID string, clusterType string,
-	obsAddonSpec oashared.ObservabilityAddonSpec,
-	hubInfo HubInfo, allowlist MetricsAllowlist, replicaCount int32) *appsv1.Deployment {
+	obsAddonSpec oashared.ObservabilityAddonSpec, hubInfo HubInfo, config EndpointConfig,
+	configHash string, replicaCount int32) *appsv1.Deployment {
+	return createShardDeployment(platformCollector, clusterID, clusterType, obsAddonSpec, hubInfo, config,
+		configHash, replicaCount)
+}
+
+// createShardDeployment renders the deployment of the shard of the metrics collector with the hub CA bundle,
+// the cluster proxy and the pod overrides, which runs with a standby replica in the high availability mode
+func createShardDeployment(kind collectorKind, clusterID string, clusterType string,
+	obsAddonSpec oashared.ObservabilityAddonSpec, hubInfo HubInfo, config EndpointConfig,
+	configHash string, replicaCount int32) *appsv1.Deployment {
+	deployment := newCollectorDeployment(kind, clusterID, clusterType, obsAddonSpec, hubInfo,
+		config.MetricsSource, configHash, replicaCount)
+	if config.HighAvailability.Enabled && replicaCount != 0 {
+		enableCollectorHA(deployment, kind)
+	}
+	config.HubCA.apply(&deployment.Spec.Template.Spec)
+	config.clusterProxy.apply(&deployment.Spec.Template.Spec)
+	config.CollectorPod.apply(&deployment.Spec.Template.Spec)
+	return deployment
+}
+
+// newCollectorDeployment renders the deployment of the metrics collector federating from the metrics source
+func newCollectorDeployment(kind collectorKind, clusterID string, clusterType string,
+	obsAddonSpec oashared.ObservabilityAddonSpec, hubInfo HubInfo, source MetricsSource,
+	configHash string, replicaCount int32) *appsv1.Deployment {
 	interval := fmt.Sprint(obsAddonSpec.Interval) + "s"
 	if fmt.Sprint(obsAddonSpec.Interval) == "" {
 		interval = defaultInterval
 	}
+	podServiceAccountName := serviceAccountName
+	if kind.serviceAccountName != "" {
+		podServiceAccountName = kind.serviceAccountName
+	}
 
 	volumes := []corev1.Volume{
 		{
@@ -88,13 +163,23 @@ func createDeployment(clusterID string, clusterType string,
 			},
 		},
 		{
-			Name: "mtlsca",
+			Name: mtlsCaVolName,
 			VolumeSource: corev1.VolumeSource{
 				Secret: &corev1.SecretVolumeSource{
 					SecretName: mtlsCaName,
 				},
 			},
 		},
+		{
+			Name: collectorConfigVolName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: kind.configName,
+					},
+				},
+			},
+		},
 	}
 	mounts := []corev1.VolumeMount{
 		{
@@ -102,9 +187,13 @@ func createDeployment(clusterID string, clusterType string,
 			MountPath: "/tlscerts/certs",
 		},
 		{
-			Name:      "mtlsca",
+			Name:      mtlsCaVolName,
 			MountPath: "/tlscerts/ca",
 		},
+		{
+			Name:      collectorConfigVolName,
+			MountPath: collectorConfigMountPath,
+		},
 	}
 	caFile := caMounthPath + "/service-ca.crt"
 	if clusterID == "" {
@@ -128,10 +217,50 @@ func createDeployment(clusterID string, clusterType string,
 		})
 	}
 
+	if source.CAConfigMap != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: sourceCAVolName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: source.CAConfigMap.Name,
+					},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      sourceCAVolName,
+			MountPath: sourceCAMountPath,
+		})
+		caFile = sourceCAMountPath + "/" + source.CAConfigMap.Key
+	}
+	tokenFile := saTokenFile
+	if source.TokenSecret != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: sourceTokenVolName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: source.TokenSecret.Name,
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      sourceTokenVolName,
+			MountPath: sourceTokenMountPath,
+		})
+		tokenFile = sourceTokenMountPath + "/" + source.TokenSecret.Key
+	}
+
+	fromURL := source.ServerURL
+	if fromURL == "" {
+		fromURL = defaultPromURL
+	}
 	hostAlias := []corev1.HostAlias{}
 	// patch for e2e test using kind cluster
 	if clusterID == kindClusterID {
-		ocpPromURL = "http://prometheus-k8s.openshift-monitoring.svc:9090"
+		if fromURL == defaultPromURL {
+			fromURL = kindPromURL
+		}
 		hostAlias = append(hostAlias, corev1.HostAlias{
 			IP:        kindClusterIP,
 			Hostnames: []string{kindClusterHost},
@@ -143,30 +272,19 @@ func createDeployment(clusterID string, clusterType string,
 		"--from=$(FROM)",
 		"--to-upload=$(TO)",
 		"--from-ca-file=" + caFile,
-		"--from-token-file=/var/run/secrets/kubernetes.io/serviceaccount/token",
+		"--from-token-file=" + tokenFile,
 		"--interval=" + interval,
 		"--limit-bytes=" + strconv.Itoa(limitBytes),
+		"--config-file=" + collectorConfigMountPath + "/" + collectorConfigKey,
 		fmt.Sprintf("--label=\"cluster=%s\"", hubInfo.ClusterName),
 		fmt.Sprintf("--label=\"clusterID=%s\"", clusterID),
 	}
 	if clusterType != "" {
 		commands = append(commands, fmt.Sprintf("--label=\"clusterType=%s\"", clusterType))
 	}
-	for _, metrics := range allowlist.NameList {
-		commands = append(commands, fmt.Sprintf("--match={__name__=\"%s\"}", metrics))
-	}
-	for _, match := range allowlist.MatchList {
-		commands = append(commands, fmt.Sprintf("--match={%s}", match))
-	}
-	for k, v := range allowlist.ReNameMap {
-		commands = append(commands, fmt.Sprintf("--rename=\"%s=%s\"", k, v))
-	}
-	for _, rule := range allowlist.RuleList {
-		commands = append(commands, fmt.Sprintf("--recordingrule={\"name\":\"%s\",\"query\":\"%s\"}", rule.Record, rule.Expr))
-	}
 	metricsCollectorDep := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      metricsCollectorName,
+			Name:      kind.name,
 			Namespace: namespace,
 			Annotations: map[string]string{
 				ownerLabelKey: ownerLabelValue,
@@ -176,18 +294,21 @@ func createDeployment(clusterID string, clusterType string,
 			Replicas: int32Ptr(replicaCount),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
-					selectorKey: selectorValue,
+					selectorKey: kind.component,
 				},
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: map[string]string{
-						selectorKey: selectorValue,
+						selectorKey: kind.component,
+					},
+					Annotations: map[string]string{
+						collectorConfigHashKey: configHash,
 					},
 				},
 				Spec: corev1.PodSpec{
 					HostAliases:        hostAlias,
-					ServiceAccountName: serviceAccountName,
+					ServiceAccountName: podServiceAccountName,
 					Containers: []corev1.Container{
 						{
 							Name:    "metrics-collector",
@@ -196,7 +317,7 @@ func createDeployment(clusterID string, clusterType string,
 							Env: []corev1.EnvVar{
 								{
 									Name:  "FROM",
-									Value: ocpPromURL,
+									Value: fromURL,
 								},
 								{
 									Name:  "TO",
@@ -218,29 +339,81 @@ func createDeployment(clusterID string, clusterType string,
 	return metricsCollectorDep
 }
 
+// updateMetricsCollector splits the allowlist across the shards of the metrics collector and deploys them.
+// It returns the number of the deployed shards, and true while the shards are being rebalanced
+// for the changed number of the shards.
 func updateMetricsCollector(ctx context.Context, client client.Client, obsAddonSpec oashared.ObservabilityAddonSpec,
-	hubInfo HubInfo, clusterID string, clusterType string,
-	replicaCount int32, forceRestart bool) (bool, error) {
+	hubInfo HubInfo, config EndpointConfig, clusterID string, clusterType string, allowlist MetricsAllowlist, keepAllowlist bool,
+	replicaCount int32, forceRestart bool) (int, bool, error) {
+	shards := config.Sharding.shards()
+	if replicaCount == 0 {
+		// no series is collected, the shards are merged into the first one without the rebalancing
+		shards = 1
+	}
+	current, err := getCollectorShards(ctx, client)
+	if err != nil {
+		return 0, false, err
+	}
+	if current != 0 && current != shards && replicaCount != 0 {
+		if keepAllowlist {
+			// the entries cannot be rebalanced without a valid allowlist
+			shards = current
+		} else {
+			rebalancing, err := rebalanceCollectorShards(ctx, client, obsAddonSpec, hubInfo, config, clusterID,
+				clusterType, allowlist, current, shards)
+			if err != nil || rebalancing {
+				return current, rebalancing, err
+			}
+			log.Info("Rebalanced the metrics collector shards", "from", current, "to", shards)
+		}
+	}
 
-	list := getMetricsAllowlist(ctx, client)
-	deployment := createDeployment(clusterID, clusterType, obsAddonSpec, hubInfo, list, replicaCount)
+	if config.HighAvailability.Enabled && replicaCount != 0 {
+		err = createCollectorLeaseRBAC(ctx, client)
+	} else {
+		err = deleteCollectorLeaseRBAC(ctx, client)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	for i := 0; i < shards; i++ {
+		_, err := applyCollectorShard(ctx, client, i, obsAddonSpec, hubInfo, config, clusterID, clusterType,
+			shardAllowlist(allowlist, i, shards), keepAllowlist, shards, replicaCount, forceRestart)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	// the shards beyond the number are left if the number is reduced while the metrics collector is disabled
+	if _, err := deleteCollectorShards(ctx, client, shards); err != nil {
+		return 0, false, err
+	}
+	return shards, false, nil
+}
+
+// applyCollectorDeployment creates the collector deployment or updates it if it is changed.
+// The pods are restarted if forceRestart is true.
+func applyCollectorDeployment(ctx context.Context, client client.Client, deployment *appsv1.Deployment,
+	hash string, forceRestart bool) error {
 	found := &appsv1.Deployment{}
-	err := client.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
+	err := client.Get(ctx, types.NamespacedName{Name: deployment.Name,
 		Namespace: namespace}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = client.Create(ctx, deployment)
 			if err != nil {
-				log.Error(err, "Failed to create metrics-collector deployment")
-				return false, err
+				log.Error(err, "Failed to create metrics-collector deployment", "name", deployment.Name)
+				return err
 			}
-			log.Info("Created metrics-collector deployment ")
+			log.Info("Created metrics-collector deployment ", "name", deployment.Name)
+			recordNormal(ctx, eventMetricsCollectorCreated, "Created the metrics collector deployment %s", deployment.Name)
 		} else {
-			log.Error(err, "Failed to check the metrics-collector deployment")
-			return false, err
+			log.Error(err, "Failed to check the metrics-collector deployment", "name", deployment.Name)
+			return err
 		}
 	} else {
 		if !reflect.DeepEqual(deployment.Spec.Template.Spec, found.Spec.Template.Spec) ||
+			found.Spec.Template.ObjectMeta.Annotations[collectorConfigHashKey] != hash ||
 			!reflect.DeepEqual(deployment.Spec.Replicas, found.Spec.Replicas) ||
 			forceRestart {
 			deployment.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
@@ -249,52 +422,153 @@ func updateMetricsCollector(ctx context.Context, client client.Client, obsAddonS
 			}
 			err = client.Update(ctx, deployment)
 			if err != nil {
-				log.Error(err, "Failed to update metrics-collector deployment")
-				return false, err
+				log.Error(err, "Failed to update metrics-collector deployment", "name", deployment.Name)
+				return err
+			}
+			log.Info("Updated metrics-collector deployment ", "name", deployment.Name)
+			if forceRestart {
+				recordNormal(ctx, eventCollectorRestartedForCertRotation,
+					"Restarted the metrics collector deployment %s for the rotated certificates", deployment.Name)
+			} else {
+				recordNormal(ctx, eventMetricsCollectorUpdated, "Updated the metrics collector deployment %s", deployment.Name)
 			}
-			log.Info("Updated metrics-collector deployment ")
 		}
 	}
-	return true, nil
+	return nil
 }
 
+// deleteMetricsCollector deletes all the shards of the metrics collector and their high availability resources
 func deleteMetricsCollector(ctx context.Context, client client.Client) error {
+	if _, err := deleteCollectorShards(ctx, client, 1); err != nil {
+		return err
+	}
+	if err := deleteCollector(ctx, client, platformCollector); err != nil {
+		return err
+	}
+	if err := deleteCollectorHA(ctx, client, platformCollector); err != nil {
+		return err
+	}
+	return deleteCollectorLeaseRBAC(ctx, client)
+}
+
+// deleteCollector deletes the collector deployment and its config
+func deleteCollector(ctx context.Context, client client.Client, kind collectorKind) error {
 	found := &appsv1.Deployment{}
-	err := client.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
+	err := client.Get(ctx, types.NamespacedName{Name: kind.name,
 		Namespace: namespace}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("The metrics collector deployment does not exist")
-			return nil
+			log.Info("The metrics collector deployment does not exist", "name", kind.name)
+			return deleteCollectorConfig(ctx, client, kind.configName)
 		}
-		log.Error(err, "Failed to check the metrics collector deployment")
+		log.Error(err, "Failed to check the metrics collector deployment", "name", kind.name)
 		return err
 	}
 	err = client.Delete(ctx, found)
 	if err != nil {
-		log.Error(err, "Failed to delete the metrics collector deployment")
+		log.Error(err, "Failed to delete the metrics collector deployment", "name", kind.name)
 		return err
 	}
-	log.Info("metrics collector deployment deleted")
-	return nil
+	log.Info("metrics collector deployment deleted", "name", kind.name)
+	recordNormal(ctx, eventMetricsCollectorDeleted, "Deleted the metrics collector deployment %s", kind.name)
+	return deleteCollectorConfig(ctx, client, kind.configName)
 }
 
 func int32Ptr(i int32) *int32 { return &i }
 
-func getMetricsAllowlist(ctx context.Context, client client.Client) MetricsAllowlist {
-	l := &MetricsAllowlist{}
-	cm := &corev1.ConfigMap{}
-	err := client.Get(ctx, types.NamespacedName{Name: metricsConfigMapName,
-		Namespace: namespace}, cm)
+// getMetricsCollectorStatus derives the status from the statuses of all the shards of the metrics collector.
+// It is Degraded if any shard is Degraded with the reason of the first one, otherwise Progressing if any shard is
+// Progressing, and the state of every shard is listed in the details if there are multiple shards
+func getMetricsCollectorStatus(ctx context.Context, c client.Client, shards int) (util.Status, error) {
+	var degraded, progressing *util.Status
+	details := []string{}
+	for i := 0; i < shards; i++ {
+		status, err := getCollectorStatus(ctx, c, collectorShard(i))
+		if err != nil {
+			return util.Status{}, err
+		}
+		details = append(details, describeShardStatus(i, status))
+		if status.Type == "Degraded" && degraded == nil {
+			degraded = &status
+		}
+		if status.Type == "Progressing" && progressing == nil {
+			progressing = &status
+		}
+	}
+	result := util.Status{Type: "Deployed"}
+	if degraded != nil {
+		result = *degraded
+	} else if progressing != nil {
+		result = *progressing
+	}
+	if shards > 1 {
+		result.Details = strings.Join(details, "; ")
+	}
+	return result, nil
+}
+
+// describeShardStatus describes the state of the shard with its index in the details of the status
+func describeShardStatus(i int, status util.Status) string {
+	desc := fmt.Sprintf("shard %d: %s", i, status.Type)
+	if status.Reason != "" {
+		desc += " (" + status.Reason + ")"
+	}
+	if status.Details != "" {
+		desc += ", " + status.Details
+	}
+	return desc
+}
+
+// getCollectorStatus derives the status from the readiness of the metrics collector deployment,
+// the rollout conditions and the container states of the metrics collector pods
+func getCollectorStatus(ctx context.Context, c client.Client, kind collectorKind) (util.Status, error) {
+	deployment := &appsv1.Deployment{}
+	err := c.Get(ctx, types.NamespacedName{Name: kind.name,
+		Namespace: namespace}, deployment)
 	if err != nil {
-		log.Error(err, "Failed to get configmap")
-	} else {
-		if cm.Data != nil {
-			err = yaml.Unmarshal([]byte(cm.Data[metricsConfigMapKey]), l)
-			if err != nil {
-				log.Error(err, "Failed to unmarshal data in configmap")
+		log.Error(err, "Failed to get the metrics-collector deployment", "name", kind.name)
+		return util.Status{}, err
+	}
+
+	pods := &corev1.PodList{}
+	err = c.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabels{selectorKey: kind.component})
+	if err != nil {
+		log.Error(err, "Failed to list the metrics-collector pods")
+		return util.Status{}, err
+	}
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && contains(failedWaitingReasons, cs.State.Waiting.Reason) {
+				return util.Status{
+					Type:    "Degraded",
+					Reason:  cs.State.Waiting.Reason,
+					Details: fmt.Sprintf("container %s in pod %s: %s", cs.Name, pod.Name, cs.State.Waiting.Message),
+				}, nil
 			}
 		}
 	}
-	return *l
+
+	for _, cond := range deployment.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse {
+			return util.Status{Type: "Degraded", Reason: cond.Reason, Details: cond.Message}, nil
+		}
+		if cond.Type == appsv1.DeploymentReplicaFailure && cond.Status == corev1.ConditionTrue {
+			return util.Status{Type: "Degraded", Reason: cond.Reason, Details: cond.Message}, nil
+		}
+	}
+
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	if deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas >= desired &&
+		deployment.Status.ReadyReplicas >= desired &&
+		deployment.Status.AvailableReplicas >= desired {
+		return util.Status{Type: "Deployed"}, nil
+	}
+	return util.Status{
+		Type:    "Progressing",
+		Details: fmt.Sprintf("%d of %d replicas ready", deployment.Status.ReadyReplicas, desired),
+	}, nil
 }
@@ -0,0 +1,244 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// the number of the replicas of every metrics collector in the high availability mode
+	haReplicas = 2
+	// the name of the role and the rolebinding granting the metrics collectors the access to their leases
+	collectorLeaseRoleName = "metrics-collector-lease"
+	leaseSuffix            = "-leader"
+	hostnameTopologyKey    = "kubernetes.io/hostname"
+)
+
+// collectorLeaseName returns the name of the lease held by the pushing replica of the collector
+func collectorLeaseName(kind collectorKind) string {
+	return kind.component + leaseSuffix
+}
+
+// enableCollectorHA runs the collector deployment with a standby replica on another node if possible,
+// the replicas elect the one pushing the metrics through the lease of the collector.
+// It is only applied in the high availability mode, since the --leader-election flags must be supported
+// by the metrics collector image.
+func enableCollectorHA(deployment *appsv1.Deployment, kind collectorKind) {
+	deployment.Spec.Replicas = int32Ptr(haReplicas)
+	spec := &deployment.Spec.Template.Spec
+	spec.Affinity = &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			// preferred so that both replicas are still scheduled in the single node clusters
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{
+								selectorKey: kind.component,
+							},
+						},
+						TopologyKey: hostnameTopologyKey,
+					},
+				},
+			},
+		},
+	}
+	container := &spec.Containers[0]
+	container.Command = append(container.Command,
+		"--leader-election",
+		"--leader-election-namespace="+namespace,
+		"--leader-election-lease="+collectorLeaseName(kind),
+		"--leader-election-identity=$(POD_NAME)",
+	)
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name: "POD_NAME",
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				APIVersion: "v1",
+				FieldPath:  "metadata.name",
+			},
+		},
+	})
+}
+
+// createCollectorPDB creates or updates the poddisruptionbudget keeping one replica of the collector available
+func createCollectorPDB(ctx context.Context, c client.Client, kind collectorKind) error {
+	minAvailable := intstr.FromInt(1)
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kind.name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					selectorKey: kind.component,
+				},
+			},
+		},
+	}
+	found := &policyv1.PodDisruptionBudget{}
+	err := c.Get(ctx, types.NamespacedName{Name: kind.name, Namespace: namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			err = c.Create(ctx, pdb)
+			if err != nil {
+				log.Error(err, "Failed to create the poddisruptionbudget", "name", kind.name)
+				return err
+			}
+			log.Info("poddisruptionbudget created", "name", kind.name)
+			return nil
+		}
+		log.Error(err, "Failed to check the poddisruptionbudget", "name", kind.name)
+		return err
+	}
+	if reflect.DeepEqual(pdb.Spec, found.Spec) {
+		return nil
+	}
+	pdb.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+	err = c.Update(ctx, pdb)
+	if err != nil {
+		log.Error(err, "Failed to update the poddisruptionbudget", "name", kind.name)
+		return err
+	}
+	log.Info("poddisruptionbudget updated", "name", kind.name)
+	return nil
+}
+
+// deleteCollectorHA deletes the poddisruptionbudget and the lease of the collector
+func deleteCollectorHA(ctx context.Context, c client.Client, kind collectorKind) error {
+	err := deleteIfExists(ctx, c, types.NamespacedName{Name: kind.name, Namespace: namespace},
+		&policyv1.PodDisruptionBudget{})
+	if err != nil {
+		return err
+	}
+	return deleteIfExists(ctx, c, types.NamespacedName{Name: collectorLeaseName(kind), Namespace: namespace},
+		&coordinationv1.Lease{})
+}
+
+// createCollectorLeaseRBAC creates or updates the role and the rolebinding granting
+// the service account of the metrics collectors the access to the leases in the namespace.
+// The metrics collectors run with the service account of the operator, which is set in SERVICE_ACCOUNT.
+func createCollectorLeaseRBAC(ctx context.Context, c client.Client) error {
+	if serviceAccountName == "" {
+		return fmt.Errorf("SERVICE_ACCOUNT is not set, the leases of the metrics collectors cannot be granted")
+	}
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      collectorLeaseRoleName,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{coordinationv1.GroupName},
+				Resources: []string{"leases"},
+				Verbs:     []string{"get", "create", "update"},
+			},
+		},
+	}
+	found := &rbacv1.Role{}
+	err := c.Get(ctx, types.NamespacedName{Name: collectorLeaseRoleName, Namespace: namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to check the role", "name", collectorLeaseRoleName)
+		return err
+	}
+	if errors.IsNotFound(err) {
+		err = c.Create(ctx, role)
+	} else if !reflect.DeepEqual(role.Rules, found.Rules) {
+		role.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+		err = c.Update(ctx, role)
+	}
+	if err != nil {
+		log.Error(err, "Failed to create or update the role", "name", collectorLeaseRoleName)
+		return err
+	}
+
+	rb := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      collectorLeaseRoleName,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "Role",
+			Name:     collectorLeaseRoleName,
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      serviceAccountName,
+				Namespace: namespace,
+			},
+		},
+	}
+	foundRB := &rbacv1.RoleBinding{}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorLeaseRoleName, Namespace: namespace}, foundRB)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to check the rolebinding", "name", collectorLeaseRoleName)
+		return err
+	}
+	if errors.IsNotFound(err) {
+		err = c.Create(ctx, rb)
+	} else if !reflect.DeepEqual(rb.RoleRef, foundRB.RoleRef) || !reflect.DeepEqual(rb.Subjects, foundRB.Subjects) {
+		rb.ObjectMeta.ResourceVersion = foundRB.ObjectMeta.ResourceVersion
+		err = c.Update(ctx, rb)
+	}
+	if err != nil {
+		log.Error(err, "Failed to create or update the rolebinding", "name", collectorLeaseRoleName)
+		return err
+	}
+	return nil
+}
+
+// deleteCollectorLeaseRBAC deletes the role and the rolebinding for the leases of the metrics collectors
+func deleteCollectorLeaseRBAC(ctx context.Context, c client.Client) error {
+	key := types.NamespacedName{Name: collectorLeaseRoleName, Namespace: namespace}
+	if err := deleteIfExists(ctx, c, key, &rbacv1.RoleBinding{}); err != nil {
+		return err
+	}
+	return deleteIfExists(ctx, c, key, &rbacv1.Role{})
+}
+
+// deleteIfExists deletes the object with the key if it exists
+func deleteIfExists(ctx context.Context, c client.Client, key types.NamespacedName, obj client.Object) error {
+	err := c.Get(ctx, key, obj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		log.Error(err, "Failed to check the resource", "name", key.Name, "type", reflect.TypeOf(obj).Elem().Name())
+		return err
+	}
+	err = c.Delete(ctx, obj)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete the resource", "name", key.Name, "type", reflect.TypeOf(obj).Elem().Name())
+		return err
+	}
+	log.Info("Deleted the resource", "name", key.Name, "type", reflect.TypeOf(obj).Elem().Name())
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+func TestMetricsCollectorHA(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+	hubInfo := HubInfo{ClusterName: "test-cluster", Endpoint: "http://test-endpoint"}
+	obsAddon := oashared.ObservabilityAddonSpec{EnableMetrics: true, Interval: 60}
+	allowlist := newShardingAllowlist()
+	config := EndpointConfig{
+		HighAvailability: CollectorHighAvailability{Enabled: true},
+		Sharding:         CollectorSharding{Shards: 2},
+	}
+
+	// the leases cannot be granted without the service account
+	_, _, err := updateMetricsCollector(ctx, c, obsAddon, hubInfo, config, testClusterID, "", allowlist, false, 1, false)
+	if err == nil {
+		t.Fatalf("Missed the error for the high availability mode without SERVICE_ACCOUNT")
+	}
+	defer func(name string) { serviceAccountName = name }(serviceAccountName)
+	serviceAccountName = "endpoint-observability-operator-sa"
+
+	_, _, err = updateMetricsCollector(ctx, c, obsAddon, hubInfo, config, testClusterID, "", allowlist, false, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update the metrics collector: (%v)", err)
+	}
+	for i := 0; i < 2; i++ {
+		kind := collectorShard(i)
+		deploy := &appsv1.Deployment{}
+		err = c.Get(ctx, types.NamespacedName{Name: kind.name, Namespace: namespace}, deploy)
+		if err != nil {
+			t.Fatalf("Failed to get the deployment of shard %d: (%v)", i, err)
+		}
+		if *deploy.Spec.Replicas != haReplicas {
+			t.Fatalf("Wrong replicas of shard %d: %d", i, *deploy.Spec.Replicas)
+		}
+		affinity := deploy.Spec.Template.Spec.Affinity
+		if affinity == nil || affinity.PodAntiAffinity == nil ||
+			affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm.
+				LabelSelector.MatchLabels[selectorKey] != kind.component {
+			t.Fatalf("Wrong anti-affinity of shard %d: %v", i, affinity)
+		}
+		if !contains(deploy.Spec.Template.Spec.Containers[0].Command,
+			"--leader-election-lease="+collectorLeaseName(kind)) {
+			t.Fatalf("No lease in the command of shard %d: %v", i, deploy.Spec.Template.Spec.Containers[0].Command)
+		}
+		pdb := &policyv1.PodDisruptionBudget{}
+		err = c.Get(ctx, types.NamespacedName{Name: kind.name, Namespace: namespace}, pdb)
+		if err != nil {
+			t.Fatalf("Failed to get the poddisruptionbudget of shard %d: (%v)", i, err)
+		}
+		if pdb.Spec.MinAvailable.IntValue() != 1 || pdb.Spec.Selector.MatchLabels[selectorKey] != kind.component {
+			t.Fatalf("Wrong poddisruptionbudget of shard %d: %v", i, pdb.Spec)
+		}
+	}
+	rb := &rbacv1.RoleBinding{}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorLeaseRoleName, Namespace: namespace}, rb)
+	if err != nil {
+		t.Fatalf("Failed to get the rolebinding for the leases: (%v)", err)
+	}
+	if rb.RoleRef.Name != collectorLeaseRoleName || rb.Subjects[0].Name != serviceAccountName {
+		t.Fatalf("Wrong rolebinding for the leases: %v", rb)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorLeaseRoleName, Namespace: namespace}, &rbacv1.Role{})
+	if err != nil {
+		t.Fatalf("Failed to get the role for the leases: (%v)", err)
+	}
+
+	// disabling the high availability mode removes the standby replica and its resources
+	err = c.Create(ctx, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: collectorLeaseName(platformCollector), Namespace: namespace},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the lease: (%v)", err)
+	}
+	config.HighAvailability.Enabled = false
+	_, _, err = updateMetricsCollector(ctx, c, obsAddon, hubInfo, config, testClusterID, "", allowlist, false, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update the metrics collector: (%v)", err)
+	}
+	deploy := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: namespace}, deploy)
+	if err != nil {
+		t.Fatalf("Failed to get the metrics collector deployment: (%v)", err)
+	}
+	if *deploy.Spec.Replicas != 1 || deploy.Spec.Template.Spec.Affinity != nil {
+		t.Fatalf("The metrics collector deployment still runs in the high availability mode")
+	}
+	for _, arg := range deploy.Spec.Template.Spec.Containers[0].Command {
+		if strings.HasPrefix(arg, "--leader-election") {
+			t.Fatalf("The leader election flag is set without the high availability mode: %s", arg)
+		}
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: namespace},
+		&policyv1.PodDisruptionBudget{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("The poddisruptionbudget is not deleted")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorLeaseName(platformCollector), Namespace: namespace},
+		&coordinationv1.Lease{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("The lease is not deleted")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorLeaseRoleName, Namespace: namespace}, &rbacv1.RoleBinding{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("The rolebinding for the leases is not deleted")
+	}
+
+	config.HighAvailability.Enabled = true
+	_, _, err = updateMetricsCollector(ctx, c, obsAddon, hubInfo, config, testClusterID, "", allowlist, false, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update the metrics collector: (%v)", err)
+	}
+	if err := deleteMetricsCollector(ctx, c); err != nil {
+		t.Fatalf("Failed to delete the metrics collector: (%v)", err)
+	}
+	pdbs := &policyv1.PodDisruptionBudgetList{}
+	if err := c.List(ctx, pdbs); err != nil || len(pdbs.Items) != 0 {
+		t.Fatalf("The poddisruptionbudgets are not deleted: %v", pdbs.Items)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorLeaseRoleName, Namespace: namespace}, &rbacv1.Role{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("The role for the leases is not deleted")
+	}
+}
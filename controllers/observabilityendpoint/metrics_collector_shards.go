@@ -0,0 +1,271 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+const (
+	// the annotation on the collector config of the first shard recording the number of the shards deployed
+	collectorShardsKey = "observability.open-cluster-management.io/collector-shards"
+	shardSuffix        = "-shard-"
+	// the interval to check the rollout of the shards while they are being rebalanced
+	shardRebalanceRequeue = 10 * time.Second
+)
+
+// collectorShard returns the collector of the shard, the first shard is the default metrics collector
+func collectorShard(i int) collectorKind {
+	if i == 0 {
+		return platformCollector
+	}
+	return collectorKind{
+		name:       fmt.Sprintf("%s%s%d", metricsCollectorName, shardSuffix, i),
+		configName: fmt.Sprintf("%s%s%d", collectorConfigName, shardSuffix, i),
+		component:  fmt.Sprintf("%s%s%d", selectorValue, shardSuffix, i),
+	}
+}
+
+// isCollectorComponent checks if the component label belongs to a shard of the metrics collector
+func isCollectorComponent(component string) bool {
+	return component == selectorValue || strings.HasPrefix(component, selectorValue+shardSuffix)
+}
+
+// shardIndex returns the shard of the key among the shards
+func shardIndex(key string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// matchShardKey returns the metric name selected by the match, so that it is in the same shard as the name.
+// The match itself is returned if it doesn't select a single metric name.
+func matchShardKey(match string) string {
+	matchers, err := parser.ParseMetricSelector("{" + match + "}")
+	if err == nil {
+		for _, m := range matchers {
+			if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+				return m.Value
+			}
+		}
+	}
+	return match
+}
+
+// shardAllowlist returns the entries of the allowlist in the shard for all the shard counts, the renames are
+// kept in every shard. With two counts it returns the entries which stay in the shard during the rebalancing.
+func shardAllowlist(allowlist MetricsAllowlist, shard int, counts ...int) MetricsAllowlist {
+	inShard := func(key string) bool {
+		for _, n := range counts {
+			if shardIndex(key, n) != shard {
+				return false
+			}
+		}
+		return true
+	}
+	result := MetricsAllowlist{ReNameMap: allowlist.ReNameMap}
+	for _, name := range allowlist.NameList {
+		if inShard(name) {
+			result.NameList = append(result.NameList, name)
+		}
+	}
+	for _, match := range allowlist.MatchList {
+		if inShard(matchShardKey(match)) {
+			result.MatchList = append(result.MatchList, match)
+		}
+	}
+	for _, rule := range allowlist.RuleList {
+		if inShard(rule.Record) {
+			result.RuleList = append(result.RuleList, rule)
+		}
+	}
+	return result
+}
+
+// getCollectorShards returns the number of the shards recorded in the collector config of the first shard,
+// it is 1 for the collector deployed without sharding and 0 if no collector config exists
+func getCollectorShards(ctx context.Context, c client.Client) (int, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: collectorConfigName, Namespace: namespace}, cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return 0, nil
+		}
+		log.Error(err, "Failed to get the metrics collector config configmap", "name", collectorConfigName)
+		return 0, err
+	}
+	shards, err := strconv.Atoi(cm.Annotations[collectorShardsKey])
+	if err != nil || shards < 1 {
+		return 1, nil
+	}
+	return shards, nil
+}
+
+// applyCollectorShard renders the config and the deployment of the shard with the allowlist,
+// and returns true if the deployment is created or its config is changed
+func applyCollectorShard(ctx context.Context, c client.Client, i int, obsAddonSpec oashared.ObservabilityAddonSpec,
+	hubInfo HubInfo, config EndpointConfig, clusterID string, clusterType string, allowlist MetricsAllowlist,
+	keepAllowlist bool, shards int, replicaCount int32, forceRestart bool) (bool, error) {
+	kind := collectorShard(i)
+	data, err := renderCollectorConfig(allowlist)
+	if err != nil {
+		log.Error(err, "Failed to render the metrics collector config", "name", kind.configName)
+		return false, err
+	}
+	var annotations map[string]string
+	if i == 0 {
+		annotations = map[string]string{collectorShardsKey: strconv.Itoa(shards)}
+	}
+	hash, err := applyCollectorConfig(ctx, c, kind.configName, data, annotations, keepAllowlist)
+	if err != nil {
+		return false, err
+	}
+	deployment := createShardDeployment(kind, clusterID, clusterType, obsAddonSpec, hubInfo, config,
+		hash, replicaCount)
+
+	found := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: kind.name, Namespace: namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to check the metrics-collector deployment", "name", kind.name)
+		return false, err
+	}
+	changed := errors.IsNotFound(err) || found.Spec.Template.ObjectMeta.Annotations[collectorConfigHashKey] != hash
+	if err := applyCollectorDeployment(ctx, c, deployment, hash, forceRestart); err != nil {
+		return false, err
+	}
+	if config.HighAvailability.Enabled && replicaCount != 0 {
+		return changed, createCollectorPDB(ctx, c, kind)
+	}
+	return changed, deleteCollectorHA(ctx, c, kind)
+}
+
+// deleteCollectorShards deletes the shards from the index on, and returns true if any of them existed
+func deleteCollectorShards(ctx context.Context, c client.Client, from int) (bool, error) {
+	indexes := map[int]bool{}
+	deployments := &appsv1.DeploymentList{}
+	if err := c.List(ctx, deployments, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "Failed to list the deployments")
+		return false, err
+	}
+	for _, d := range deployments.Items {
+		if i, ok := parseShardIndex(d.Name, metricsCollectorName); ok && i >= from {
+			indexes[i] = true
+		}
+	}
+	cms := &corev1.ConfigMapList{}
+	if err := c.List(ctx, cms, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "Failed to list the configmaps")
+		return false, err
+	}
+	for _, cm := range cms.Items {
+		if i, ok := parseShardIndex(cm.Name, collectorConfigName); ok && i >= from {
+			indexes[i] = true
+		}
+	}
+	for i := range indexes {
+		if err := deleteCollector(ctx, c, collectorShard(i)); err != nil {
+			return false, err
+		}
+		if err := deleteCollectorHA(ctx, c, collectorShard(i)); err != nil {
+			return false, err
+		}
+	}
+	return len(indexes) != 0, nil
+}
+
+// parseShardIndex returns the index of the shard if the name is the name of a shard with the prefix
+func parseShardIndex(name string, prefix string) (int, bool) {
+	if !strings.HasPrefix(name, prefix+shardSuffix) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(name, prefix+shardSuffix))
+	if err != nil || i < 1 {
+		return 0, false
+	}
+	return i, true
+}
+
+// collectorShardsSettled checks that the deployments of the first shards are rolled out without the pods of the
+// old revision, and no pod of the shards from the index on is left
+func collectorShardsSettled(ctx context.Context, c client.Client, first int, from int, to int) (bool, error) {
+	for i := 0; i < first; i++ {
+		kind := collectorShard(i)
+		d := &appsv1.Deployment{}
+		err := c.Get(ctx, types.NamespacedName{Name: kind.name, Namespace: namespace}, d)
+		if err != nil {
+			log.Error(err, "Failed to get the metrics-collector deployment", "name", kind.name)
+			return false, err
+		}
+		desired := int32(1)
+		if d.Spec.Replicas != nil {
+			desired = *d.Spec.Replicas
+		}
+		if d.Status.ObservedGeneration < d.Generation || d.Status.UpdatedReplicas != desired ||
+			d.Status.Replicas != desired {
+			return false, nil
+		}
+	}
+	for i := from; i < to; i++ {
+		pods := &corev1.PodList{}
+		err := c.List(ctx, pods, client.InNamespace(namespace),
+			client.MatchingLabels{selectorKey: collectorShard(i).component})
+		if err != nil {
+			log.Error(err, "Failed to list the metrics-collector pods")
+			return false, err
+		}
+		if len(pods.Items) != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// rebalanceCollectorShards prepares the rebalancing from the current number of the shards to the new one.
+// The entries moving to another shard are removed from the existing shards first, and the shards beyond the new
+// number are deleted. It returns true until the shards are rolled out with the remaining entries, so that no series
+// is collected by two shards at the same time.
+func rebalanceCollectorShards(ctx context.Context, c client.Client, obsAddonSpec oashared.ObservabilityAddonSpec,
+	hubInfo HubInfo, config EndpointConfig, clusterID string, clusterType string, allowlist MetricsAllowlist,
+	current int, shards int) (bool, error) {
+	remaining := current
+	if shards < remaining {
+		remaining = shards
+	}
+	changed := false
+	for i := 0; i < remaining; i++ {
+		shardChanged, err := applyCollectorShard(ctx, c, i, obsAddonSpec, hubInfo, config, clusterID, clusterType,
+			shardAllowlist(allowlist, i, current, shards), false, current, 1, false)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || shardChanged
+	}
+	deleted, err := deleteCollectorShards(ctx, c, shards)
+	if err != nil {
+		return false, err
+	}
+	if changed || deleted {
+		log.Info("Rebalancing the metrics collector shards", "from", current, "to", shards)
+		return true, nil
+	}
+	settled, err := collectorShardsSettled(ctx, c, remaining, shards, current)
+	if err != nil {
+		return false, err
+	}
+	return !settled, nil
+}
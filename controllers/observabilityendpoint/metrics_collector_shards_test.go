@@ -0,0 +1,207 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+func newShardingAllowlist() MetricsAllowlist {
+	allowlist := MetricsAllowlist{
+		MatchList: []string{`__name__="metric_0",job="apiserver"`, `job="kubelet"`},
+		ReNameMap: map[string]string{"metric_1": "metric_one"},
+		RuleList:  []Rule{{Record: "rule_0", Expr: "sum(metric_0)"}},
+	}
+	for i := 0; i < 20; i++ {
+		allowlist.NameList = append(allowlist.NameList, fmt.Sprintf("metric_%d", i))
+	}
+	return allowlist
+}
+
+func TestShardAllowlist(t *testing.T) {
+	allowlist := newShardingAllowlist()
+	seen := map[string]int{}
+	for i := 0; i < 3; i++ {
+		shard := shardAllowlist(allowlist, i, 3)
+		if shard.ReNameMap["metric_1"] != "metric_one" {
+			t.Fatalf("Renames not kept in shard %d", i)
+		}
+		for _, entry := range append(shard.NameList, shard.MatchList...) {
+			seen[entry]++
+		}
+		for _, rule := range shard.RuleList {
+			seen[rule.Record]++
+		}
+		for _, match := range shard.MatchList {
+			if match == allowlist.MatchList[0] && !contains(shard.NameList, "metric_0") {
+				t.Fatalf("The match of metric_0 is not in the same shard as the name")
+			}
+		}
+	}
+	for _, entry := range append(append(allowlist.NameList, allowlist.MatchList...), "rule_0") {
+		if seen[entry] != 1 {
+			t.Fatalf("Entry %s is in %d shards", entry, seen[entry])
+		}
+	}
+
+	// only the entries staying in the same shard are kept during the rebalancing
+	for _, name := range shardAllowlist(allowlist, 0, 3, 2).NameList {
+		if shardIndex(name, 3) != 0 || shardIndex(name, 2) != 0 {
+			t.Fatalf("Entry %s moving to another shard is kept", name)
+		}
+	}
+}
+
+// getShardEntries returns the shards of the entries in the collector configs of the shards
+func getShardEntries(t *testing.T, c client.Client, shards int) map[string][]int {
+	entries := map[string][]int{}
+	for i := 0; i < shards; i++ {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(context.TODO(), types.NamespacedName{Name: collectorShard(i).configName, Namespace: namespace}, cm)
+		if err != nil {
+			t.Fatalf("Failed to get the collector config of shard %d: (%v)", i, err)
+		}
+		config := CollectorConfig{}
+		if err := yaml.Unmarshal([]byte(cm.Data[collectorConfigKey]), &config); err != nil {
+			t.Fatalf("Failed to unmarshal the collector config of shard %d: (%v)", i, err)
+		}
+		for _, match := range config.Matches {
+			entries[match] = append(entries[match], i)
+		}
+	}
+	return entries
+}
+
+// setShardsRolledOut sets the status of the shard deployments as rolled out
+func setShardsRolledOut(t *testing.T, c client.Client, shards int) {
+	for i := 0; i < shards; i++ {
+		d := &appsv1.Deployment{}
+		err := c.Get(context.TODO(), types.NamespacedName{Name: collectorShard(i).name, Namespace: namespace}, d)
+		if err != nil {
+			t.Fatalf("Failed to get the deployment of shard %d: (%v)", i, err)
+		}
+		d.Status.Replicas = 1
+		d.Status.UpdatedReplicas = 1
+		d.Status.ReadyReplicas = 1
+		d.Status.AvailableReplicas = 1
+		if err := c.Update(context.TODO(), d); err != nil {
+			t.Fatalf("Failed to update the deployment of shard %d: (%v)", i, err)
+		}
+	}
+}
+
+func TestMetricsCollectorShards(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+	hubInfo := HubInfo{ClusterName: "test-cluster", Endpoint: "http://test-endpoint"}
+	obsAddon := oashared.ObservabilityAddonSpec{EnableMetrics: true, Interval: 60}
+	allowlist := newShardingAllowlist()
+	config := EndpointConfig{}
+
+	update := func() (int, bool) {
+		shards, rebalancing, err := updateMetricsCollector(ctx, c, obsAddon, hubInfo, config, testClusterID, "",
+			allowlist, false, 1, false)
+		if err != nil {
+			t.Fatalf("Failed to update the metrics collector: (%v)", err)
+		}
+		return shards, rebalancing
+	}
+
+	if shards, rebalancing := update(); shards != 1 || rebalancing {
+		t.Fatalf("Wrong shards without sharding: %d, rebalancing: %v", shards, rebalancing)
+	}
+	setShardsRolledOut(t, c, 1)
+
+	// scale up, the entries moving to the new shards are removed from the first shard before they are added
+	config.Sharding.Shards = 3
+	if shards, rebalancing := update(); shards != 1 || !rebalancing {
+		t.Fatalf("Not rebalancing to 3 shards: %d, rebalancing: %v", shards, rebalancing)
+	}
+	for entry := range getShardEntries(t, c, 1) {
+		if !strings.Contains(entry, "metric_") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(entry, `{__name__="`), `"}`)
+		if !strings.Contains(name, ",") && shardIndex(name, 3) != 0 {
+			t.Fatalf("Entry %s moving to another shard is still in the first shard", entry)
+		}
+	}
+	err := c.Get(ctx, types.NamespacedName{Name: collectorShard(1).name, Namespace: namespace}, &appsv1.Deployment{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("New shard created before the existing shards are rolled out")
+	}
+	if _, rebalancing := update(); !rebalancing {
+		t.Fatalf("Rebalanced before the first shard is rolled out")
+	}
+	setShardsRolledOut(t, c, 1)
+	if shards, rebalancing := update(); shards != 3 || rebalancing {
+		t.Fatalf("Not rebalanced to 3 shards: %d, rebalancing: %v", shards, rebalancing)
+	}
+	entries := getShardEntries(t, c, 3)
+	if len(entries) != len(allowlist.NameList)+len(allowlist.MatchList) {
+		t.Fatalf("Wrong number of entries in the shards: %v", entries)
+	}
+	for entry, shards := range entries {
+		if len(shards) != 1 {
+			t.Fatalf("Entry %s is in multiple shards: %v", entry, shards)
+		}
+	}
+	status, err := getMetricsCollectorStatus(ctx, c, 3)
+	if err != nil {
+		t.Fatalf("Failed to get the metrics collector status: (%v)", err)
+	}
+	// the state of every shard is reported
+	if status.Type != "Progressing" || !strings.HasPrefix(status.Details, "shard 0: Deployed; shard 1: Progressing, ") ||
+		!strings.Contains(status.Details, "; shard 2: Progressing, ") {
+		t.Fatalf("Wrong metrics collector status: (%v)", status)
+	}
+	setShardsRolledOut(t, c, 3)
+	status, err = getMetricsCollectorStatus(ctx, c, 3)
+	if err != nil {
+		t.Fatalf("Failed to get the metrics collector status: (%v)", err)
+	}
+	if status.Type != "Deployed" || status.Details != "shard 0: Deployed; shard 1: Deployed; shard 2: Deployed" {
+		t.Fatalf("Wrong metrics collector status: (%v)", status)
+	}
+
+	// scale down, the removed shards are deleted before their entries are added to the remaining shard
+	config.Sharding.Shards = 1
+	if _, rebalancing := update(); !rebalancing {
+		t.Fatalf("Not rebalancing to 1 shard")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorShard(2).configName, Namespace: namespace}, &corev1.ConfigMap{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("The config of the removed shard is not deleted")
+	}
+	if shards, rebalancing := update(); shards != 1 || rebalancing {
+		t.Fatalf("Not rebalanced to 1 shard: %d, rebalancing: %v", shards, rebalancing)
+	}
+	if entries := getShardEntries(t, c, 1); len(entries) != len(allowlist.NameList)+len(allowlist.MatchList) {
+		t.Fatalf("Wrong number of entries in the first shard: %v", entries)
+	}
+
+	config.Sharding.Shards = 2
+	update()
+	setShardsRolledOut(t, c, 1)
+	update()
+	if err := deleteMetricsCollector(ctx, c); err != nil {
+		t.Fatalf("Failed to delete the metrics collector: (%v)", err)
+	}
+	deployments := &appsv1.DeploymentList{}
+	if err := c.List(ctx, deployments); err != nil || len(deployments.Items) != 0 {
+		t.Fatalf("The shards are not deleted: (%v)", deployments.Items)
+	}
+}
@@ -4,10 +4,14 @@ package observabilityendpoint
 
 import (
 	"context"
+	"strings"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/kubectl/pkg/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
@@ -28,7 +32,7 @@ names:
   - a
   - b
 matches:
-  - c
+  - __name__="c"
 rules:
   - record: f
     expr: g
@@ -58,29 +62,63 @@ func TestMetricsCollector(t *testing.T) {
 
 	ctx := context.TODO()
 	c := fake.NewFakeClient(allowlistCM)
+	list, err := getMetricsAllowlist(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to get metrics allowlist: (%v)", err)
+	}
+	config, err := getEndpointConfig(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to get endpoint config: (%v)", err)
+	}
 	// Default deployment with instance count 1
-	_, err := updateMetricsCollector(ctx, c, obsAddon, *hubInfo, testClusterID, "", 1, false)
+	_, _, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, *config, testClusterID, "", list, false, 1, false)
 	if err != nil {
 		t.Fatalf("Failed to create metrics collector deployment: (%v)", err)
 	}
 	// Update deployment to reduce instance count to zero
-	_, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, testClusterID, "", 0, false)
+	_, _, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, *config, testClusterID, "", list, false, 0, false)
 	if err != nil {
 		t.Fatalf("Failed to create metrics collector deployment: (%v)", err)
 	}
 
-	_, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, testClusterID+"-update", "SNO", 1, false)
+	_, _, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, *config, testClusterID+"-update", "SNO", list, false, 1, false)
 	if err != nil {
 		t.Fatalf("Failed to create metrics collector deployment: (%v)", err)
 	}
 
-	_, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, testClusterID+"-update", "SNO", 1, true)
+	_, _, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, *config, testClusterID+"-update", "SNO", list, false, 1, true)
 	if err != nil {
 		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
 	}
 
+	// Invalid allowlist should keep the last known good allowlist deployed
+	_, _, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, *config, testClusterID+"-update", "SNO", MetricsAllowlist{}, true, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorConfigName, Namespace: namespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get metrics collector config configmap: (%v)", err)
+	}
+	if !strings.Contains(cm.Data[collectorConfigKey], "{__name__=\"a\"}") {
+		t.Fatalf("Last known good allowlist not kept: (%v)", cm.Data[collectorConfigKey])
+	}
+	deploy := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: namespace}, deploy)
+	if err != nil {
+		t.Fatalf("Failed to get metrics collector deployment: (%v)", err)
+	}
+	if deploy.Spec.Template.ObjectMeta.Annotations[collectorConfigHashKey] != configHash(cm.Data[collectorConfigKey]) {
+		t.Fatalf("Wrong config hash annotation in metrics collector deployment")
+	}
+
 	err = deleteMetricsCollector(ctx, c)
 	if err != nil {
 		t.Fatalf("Failed to delete metrics collector deployment: (%v)", err)
 	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorConfigName, Namespace: namespace}, cm)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Metrics collector config configmap not deleted")
+	}
 }
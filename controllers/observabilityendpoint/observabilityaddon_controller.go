@@ -4,17 +4,25 @@ package observabilityendpoint
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"sync"
+	"time"
 
+	ocinfrav1 "github.com/openshift/api/config/v1"
 	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/source"
@@ -48,6 +56,27 @@ type ObservabilityAddonReconciler struct {
 	Client    client.Client
 	Scheme    *runtime.Scheme
 	HubClient client.Client
+	// ResyncPeriod is the interval to reconcile again after a successful reconcile,
+	// so that the drift not caught by the watches is corrected. It is disabled if it is 0.
+	ResyncPeriod time.Duration
+	// HubBackoff is the retry policy when the hub cluster is unreachable
+	HubBackoff *util.Backoff
+	// HubProvider provides the hub config to watch the observabilityaddon in the hub cluster,
+	// the hub observabilityaddon is not watched if it is nil
+	HubProvider *util.HubClientProvider
+
+	// Recorder emits the events for the managed resource changes on the local observabilityaddon
+	Recorder record.EventRecorder
+	// AppAllowlistCache holds the labeled allowlist configmaps in the application namespaces,
+	// the allowlists in the application namespaces are ignored if it is nil
+	AppAllowlistCache cache.Cache
+
+	mu sync.Mutex
+	// seriesQuerier counts the series for the cardinality budget, the metrics source is queried if it is nil
+	seriesQuerier seriesQuerier
+	seriesCounts  seriesCountCache
+	// hubCABundleRef is the name of the configmap configured in hubCA.caBundle, which is watched for the changes
+	hubCABundleRef string
 }
 
 // +kubebuilder:rbac:groups=observability.open-cluster-management.io.open-cluster-management.io,resources=observabilityaddons,verbs=get;list;watch;create;update;patch;delete
@@ -59,15 +88,46 @@ type ObservabilityAddonReconciler struct {
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
 func (r *ObservabilityAddonReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	result, err := r.reconcile(ctx, req)
+	util.RecordReconcileResult(err)
+	return result, err
+}
+
+func (r *ObservabilityAddonReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.WithValues("Request.Namespace", req.Namespace, "Request.Name", req.Name)
 	log.Info("Reconciling")
 
+	result := ctrl.Result{RequeueAfter: r.ResyncPeriod}
+
 	// Fetch the ObservabilityAddon instance in hub cluster
 	hubObsAddon := &oav1beta1.ObservabilityAddon{}
+	hubReachable := true
 	err := r.HubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: hubNamespace}, hubObsAddon)
+	util.RecordStep(util.StepHubFetch, err)
+	util.RecordHubReachable(err == nil || errors.IsNotFound(err))
 	if err != nil {
-		log.Error(err, "Failed to get observabilityaddon", "namespace", hubNamespace)
-		return ctrl.Result{}, err
+		if errors.IsNotFound(err) {
+			log.Error(err, "Failed to get observabilityaddon", "namespace", hubNamespace)
+			return ctrl.Result{}, err
+		}
+		// keep managing the local resources with the last known observabilityaddon while the hub is unreachable
+		hubErr := err
+		hubReachable = false
+		result.RequeueAfter = r.hubBackoff().Next()
+		hubObsAddon, err = loadHubObsAddon(ctx, r.Client)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if hubObsAddon == nil {
+			log.Error(hubErr, "Hub cluster is unreachable and no observabilityaddon is known",
+				"namespace", hubNamespace, "retryAfter", result.RequeueAfter)
+			return result, nil
+		}
+		log.Error(hubErr, "Hub cluster is unreachable, use the last known observabilityaddon",
+			"namespace", hubNamespace, "retryAfter", result.RequeueAfter)
+		ctx = withHubUnreachable(ctx, hubErr)
+	} else {
+		r.hubBackoff().Reset()
 	}
 
 	// Fetch the ObservabilityAddon instance in local cluster
@@ -80,39 +140,99 @@ func (r *ObservabilityAddonReconciler) Reconcile(ctx context.Context, req ctrl.R
 			log.Error(err, "Failed to get observabilityaddon", "namespace", namespace)
 			return ctrl.Result{}, err
 		}
+	} else {
+		ctx = withEvents(ctx, r.Recorder, obsAddon)
 	}
 
 	// Init finalizers
 	deleteFlag := false
-	if obsAddon == nil {
+	if obsAddon == nil || (hubReachable && hubObsAddon.DeletionTimestamp != nil) {
 		deleteFlag = true
 	}
-	deleted, err := r.initFinalization(ctx, deleteFlag, hubObsAddon)
+	if !hubReachable {
+		if deleteFlag {
+			// the cleanup is done once the finalizer can be removed from the hub observabilityaddon
+			log.Info("Wait for the hub cluster to be reachable to clean up")
+			return result, nil
+		}
+	} else {
+		deleted, err := r.initFinalization(ctx, deleteFlag, hubObsAddon)
+		util.RecordStep(util.StepFinalizer, err)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if deleted || deleteFlag {
+			return ctrl.Result{}, nil
+		}
+		err = saveHubObsAddon(ctx, r.Client, hubObsAddon)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	endpointConfig, err := getEndpointConfig(ctx, r.Client)
 	if err != nil {
+		reason := "InvalidEndpointConfig"
+		if _, ok := err.(*podOverridesError); ok {
+			reason = "InvalidCollectorPodOverrides"
+		}
+		r.reportDegraded(ctx, obsAddon, reason, err)
 		return ctrl.Result{}, err
 	}
-	if deleted || deleteFlag {
-		return ctrl.Result{}, nil
+	platform, err := detectPlatform(ctx, r.Client, endpointConfig.Platform)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
-	// If no prometheus service found, set status as NotSupported
-	promSvc := &corev1.Service{}
-	err = r.Client.Get(ctx, types.NamespacedName{Name: promSvcName,
-		Namespace: promNamespace}, promSvc)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			log.Error(err, "OCP prometheus service does not exist")
-			util.ReportStatus(ctx, r.Client, obsAddon, "NotSupported")
-			return ctrl.Result{}, nil
+	if endpointConfig.MetricsSource.ServerURL == "" {
+		if platform == platformOpenShift {
+			endpointConfig.MetricsSource.ServerURL = defaultPromURL
+		} else {
+			// If no prometheus deployed by prometheus-operator found, set status as NotSupported
+			promURL, found, err := getPromOperatorURL(ctx, r.Client)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if !found {
+				log.Info("No prometheus deployed by prometheus-operator found")
+				r.reportStatuses(ctx, obsAddon, util.Status{Type: "NotSupported",
+					Details: "no prometheus deployed by prometheus-operator found"})
+				return result, nil
+			}
+			endpointConfig.MetricsSource.ServerURL = promURL
 		}
-		log.Error(err, "Failed to check prometheus resource")
-		return ctrl.Result{}, err
 	}
 
-	clusterID, err := getClusterID(ctx, r.Client)
-	if err != nil {
-		// OCP 3.11 has no cluster id, set it as empty string
-		clusterID = ""
+	// If no prometheus service found for the metrics source, set status as NotSupported
+	if svcName, svcNamespace, ok := getSourceService(endpointConfig.MetricsSource.ServerURL); ok {
+		promSvc := &corev1.Service{}
+		err = r.Client.Get(ctx, types.NamespacedName{Name: svcName,
+			Namespace: svcNamespace}, promSvc)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				log.Error(err, "Prometheus service for the metrics source does not exist",
+					"name", svcName, "namespace", svcNamespace)
+				r.reportStatuses(ctx, obsAddon, util.Status{Type: "NotSupported",
+					Details: fmt.Sprintf("service %s/%s not found", svcNamespace, svcName)})
+				return result, nil
+			}
+			log.Error(err, "Failed to check prometheus resource")
+			return ctrl.Result{}, err
+		}
+	}
+
+	var clusterID string
+	if platform == platformOpenShift {
+		clusterID, err = getClusterID(ctx, r.Client)
+		if err != nil {
+			// OCP 3.11 has no cluster id, set it as empty string
+			clusterID = ""
+		}
+	} else {
+		clusterID, err = getKubeClusterID(ctx, r.Client)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	clusterType := ""
@@ -121,58 +241,189 @@ func (r *ObservabilityAddonReconciler) Reconcile(ctx context.Context, req ctrl.R
 		clusterType = "SNO"
 	}
 
+	if platform == platformOpenShift {
+		endpointConfig.clusterProxy, err = getClusterProxy(ctx, r.Client)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	injectTrustedCA := (endpointConfig.clusterProxy != nil && endpointConfig.clusterProxy.TrustedCA) ||
+		(platform == platformOpenShift && endpointConfig.HubCA.InjectTrustedCABundle)
+	err = updateTrustedCABundle(ctx, r.Client, injectTrustedCA)
+	if err != nil {
+		r.reportDegraded(ctx, obsAddon, "TrustedCABundleFailed", err)
+		return ctrl.Result{}, err
+	}
+
 	err = createMonitoringClusterRoleBinding(ctx, r.Client)
+	util.RecordStep(util.StepClusterRoleBinding, err)
 	if err != nil {
+		r.reportDegraded(ctx, obsAddon, "ClusterRoleBindingFailed", err)
 		return ctrl.Result{}, err
 	}
-	err = createCAConfigmap(ctx, r.Client)
+	if platform == platformOpenShift {
+		err = createCAConfigmap(ctx, r.Client)
+	} else {
+		err = createKubeCAConfigmap(ctx, r.Client)
+	}
+	util.RecordStep(util.StepCAConfigMap, err)
 	if err != nil {
+		r.reportDegraded(ctx, obsAddon, "CAConfigMapFailed", err)
 		return ctrl.Result{}, err
 	}
 
 	hubSecret := &corev1.Secret{}
 	err = r.Client.Get(ctx, types.NamespacedName{Name: hubConfigName, Namespace: namespace}, hubSecret)
 	if err != nil {
+		if errors.IsNotFound(err) {
+			r.reportDegraded(ctx, obsAddon, "HubSecretMissing", err)
+		}
 		return ctrl.Result{}, err
 	}
 	hubInfo := &HubInfo{}
 	err = yaml.Unmarshal(hubSecret.Data[hubInfoKey], &hubInfo)
 	if err != nil {
 		log.Error(err, "Failed to unmarshal hub info")
+		r.reportDegraded(ctx, obsAddon, "InvalidHubInfo", err)
 		return ctrl.Result{}, err
 	}
 	hubInfo.ClusterName = string(hubSecret.Data[clusterNameKey])
 
+	r.setHubCABundleRef(endpointConfig.HubCA)
+	hubCABundle, err := getHubCABundle(ctx, r.Client, endpointConfig.HubCA)
+	if err != nil {
+		r.reportDegraded(ctx, obsAddon, "InvalidHubCABundle", err)
+		return ctrl.Result{}, err
+	}
+	err = updateHubCABundle(ctx, r.Client, endpointConfig.HubCA, hubCABundle)
+	if err != nil {
+		r.reportDegraded(ctx, obsAddon, "HubCABundleFailed", err)
+		return ctrl.Result{}, err
+	}
+
 	// create or update the cluster-monitoring-config configmap and relevant resources
-	if err := createOrUpdateClusterMonitoringConfig(ctx, hubInfo, clusterID, r.Client); err != nil {
+	forwardingStatus := util.Status{Type: "AlertForwardingProxyNotRequired"}
+	if platform == platformOpenShift {
+		forwarding := withClusterProxy(endpointConfig.AlertForwarding, *hubInfo, endpointConfig.clusterProxy)
+		forwarding.caBundle = hubCABundle
+		if forwarding.isEnabled() && forwarding.ProxyURL != "" {
+			forwardingStatus = util.Status{Type: "AlertForwardingProxyUnsupported"}
+		}
+		err := createOrUpdateClusterMonitoringConfig(ctx, hubInfo, clusterID, forwarding, r.Client)
+		util.RecordStep(util.StepClusterMonitoringConfig, err)
+		if err != nil {
+			r.reportDegraded(ctx, obsAddon, "ClusterMonitoringConfigFailed", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	allowlistCMs, err := getAllowlistConfigMaps(ctx, r.Client)
+	if err != nil {
+		// keep the last known good allowlist until the configmaps can be read again
 		return ctrl.Result{}, err
 	}
+	allowlist, allowlistErr := mergeAllowlistConfigMaps(allowlistCMs)
+	if allowlistErr == nil && r.AppAllowlistCache != nil {
+		allowlistErr = mergeAppMetricsAllowlists(ctx, r.AppAllowlistCache, &allowlist)
+	}
+	util.RecordAllowlistSize(len(allowlist.NameList), len(allowlist.MatchList),
+		len(allowlist.ReNameMap), len(allowlist.RuleList))
+	allowlistStatus := util.Status{Type: "ValidAllowlist"}
+	if allowlistErr != nil {
+		log.Error(allowlistErr, "Invalid metrics allowlist, keep the last known good allowlist")
+		allowlistStatus = util.Status{Type: "InvalidAllowlist", Details: allowlistErr.Error()}
+	}
+	cardinalityStatus := util.Status{Type: "WithinCardinalityBudget"}
+	if obsAddon.Spec.EnableMetrics && allowlistErr == nil {
+		allowlist, cardinalityStatus = r.applyCardinalityBudget(ctx, *endpointConfig, allowlist,
+			platform == platformOpenShift && endpointConfig.UserWorkloadMetrics.Enabled)
+	}
 
 	if obsAddon.Spec.EnableMetrics {
 		forceRestart := false
-		if req.Name == mtlsCertName || req.Name == mtlsCaName || req.Name == caConfigmapName {
+		if req.Name == mtlsCertName || req.Name == mtlsCaName || req.Name == caConfigmapName ||
+			req.Name == trustedCABundleName || req.Name == hubCABundleName {
 			forceRestart = true
 		}
-		created, err := updateMetricsCollector(ctx, r.Client, obsAddon.Spec, *hubInfo, clusterID, clusterType, 1, forceRestart)
+		shards, rebalancing, err := updateMetricsCollector(ctx, r.Client, obsAddon.Spec, *hubInfo, *endpointConfig,
+			clusterID, clusterType, allowlist, allowlistErr != nil, 1, forceRestart)
+		util.RecordStep(util.StepMetricsCollector, err)
 		if err != nil {
-			util.ReportStatus(ctx, r.Client, obsAddon, "Degraded")
+			r.reportStatuses(ctx, obsAddon,
+				util.Status{Type: "Degraded", Details: err.Error()}, allowlistStatus, cardinalityStatus, forwardingStatus)
 			return ctrl.Result{}, err
 		}
-		if created {
-			util.ReportStatus(ctx, r.Client, obsAddon, "Deployed")
+		// the user workload monitoring stack is only available in openshift
+		if platform == platformOpenShift {
+			err = updateUWLMetricsCollector(ctx, r.Client, obsAddon.Spec, *hubInfo, *endpointConfig, clusterID, clusterType,
+				allowlist, allowlistErr != nil, forceRestart)
+		} else {
+			err = deleteUWLMetricsCollector(ctx, r.Client)
+		}
+		util.RecordStep(util.StepUWLMetricsCollector, err)
+		if err != nil {
+			r.reportStatuses(ctx, obsAddon,
+				util.Status{Type: "Degraded", Reason: "UserWorkloadCollectorFailed", Details: err.Error()},
+				allowlistStatus, cardinalityStatus, forwardingStatus)
+			return ctrl.Result{}, err
+		}
+		if rebalancing {
+			r.reportStatuses(ctx, obsAddon, util.Status{Type: "Progressing", Reason: "Rebalancing",
+				Details: fmt.Sprintf("rebalancing the metrics collector from %d to %d shards", shards,
+					endpointConfig.Sharding.shards())}, allowlistStatus, cardinalityStatus, forwardingStatus)
+			if result.RequeueAfter == 0 || result.RequeueAfter > shardRebalanceRequeue {
+				result.RequeueAfter = shardRebalanceRequeue
+			}
+			return result, nil
+		}
+		collectorStatus, err := getMetricsCollectorStatus(ctx, r.Client, shards)
+		if err != nil {
+			return ctrl.Result{}, err
 		}
+		r.reportStatuses(ctx, obsAddon, collectorStatus, allowlistStatus, cardinalityStatus, forwardingStatus)
 	} else {
-		deleted, err := updateMetricsCollector(ctx, r.Client, obsAddon.Spec, *hubInfo, clusterID, clusterType, 0, false)
+		_, _, err := updateMetricsCollector(ctx, r.Client, obsAddon.Spec, *hubInfo, *endpointConfig, clusterID, clusterType,
+			allowlist, allowlistErr != nil, 0, false)
+		util.RecordStep(util.StepMetricsCollector, err)
 		if err != nil {
+			r.reportDegraded(ctx, obsAddon, "Degraded", err)
 			return ctrl.Result{}, err
 		}
-		if deleted {
-			util.ReportStatus(ctx, r.Client, obsAddon, "Disabled")
+		err = deleteUWLMetricsCollector(ctx, r.Client)
+		util.RecordStep(util.StepUWLMetricsCollector, err)
+		if err != nil {
+			r.reportDegraded(ctx, obsAddon, "UserWorkloadCollectorFailed", err)
+			return ctrl.Result{}, err
 		}
+		r.reportStatuses(ctx, obsAddon, util.Status{Type: "Disabled"}, allowlistStatus, cardinalityStatus, forwardingStatus)
+	}
+
+	return result, nil
+}
+
+func (r *ObservabilityAddonReconciler) hubBackoff() *util.Backoff {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.HubBackoff == nil {
+		r.HubBackoff = &util.Backoff{}
+	}
+	return r.HubBackoff
+}
+
+// setHubCABundleRef records the configmap configured in hubCA.caBundle to watch
+func (r *ObservabilityAddonReconciler) setHubCABundleRef(hubCA HubCA) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hubCABundleRef = ""
+	if hubCA.CABundle != nil {
+		r.hubCABundleRef = hubCA.CABundle.Name
 	}
+}
 
-	//TODO: UPDATE
-	return ctrl.Result{}, nil
+func (r *ObservabilityAddonReconciler) getHubCABundleRef() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hubCABundleRef
 }
 
 func (r *ObservabilityAddonReconciler) initFinalization(
@@ -183,6 +434,10 @@ func (r *ObservabilityAddonReconciler) initFinalization(
 		if err != nil {
 			return false, err
 		}
+		err = deleteUWLMetricsCollector(ctx, r.Client)
+		if err != nil {
+			return false, err
+		}
 		// Should we return bool from the delete functions for crb and cm? What is it used for? Should we use the bool before removing finalizer?
 		// SHould we return true if metricscollector is not found as that means  metrics collector is not present?
 		// Moved this part up as we need to clean up cm and crb before we remove the finalizer - is that the right way to do it?
@@ -194,8 +449,26 @@ func (r *ObservabilityAddonReconciler) initFinalization(
 		if err != nil {
 			return false, err
 		}
-		// revert the change to openshift cluster monitoring stack
-		err = revertClusterMonitoringConfig(ctx, r.Client)
+		// revert the change to openshift cluster monitoring stack, the cluster id is used to find the settings
+		// injected by the former versions, OCP 3.11 has no cluster id
+		clusterID, _ := getClusterID(ctx, r.Client)
+		err = revertClusterMonitoringConfig(ctx, clusterID, r.Client)
+		if err != nil {
+			return false, err
+		}
+		err = deleteHubCache(ctx, r.Client)
+		if err != nil {
+			return false, err
+		}
+		err = deleteCardinalityStatus(ctx, r.Client)
+		if err != nil {
+			return false, err
+		}
+		err = deleteTrustedCABundle(ctx, r.Client)
+		if err != nil {
+			return false, err
+		}
+		err = deleteHubCABundle(ctx, r.Client)
 		if err != nil {
 			return false, err
 		}
@@ -220,21 +493,80 @@ func (r *ObservabilityAddonReconciler) initFinalization(
 	return false, nil
 }
 
+// reportDegraded reports the Degraded status with the reason and the underlying error
+func (r *ObservabilityAddonReconciler) reportDegraded(ctx context.Context, obsAddon *oav1beta1.ObservabilityAddon,
+	reason string, err error) {
+	recordWarning(ctx, reason, "%s", err.Error())
+	r.reportStatuses(ctx, obsAddon, util.Status{Type: "Degraded", Reason: reason, Details: err.Error()})
+}
+
+type hubUnreachableKey struct{}
+
+// withHubUnreachable returns the context carrying the error of the unreachable hub cluster
+func withHubUnreachable(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, hubUnreachableKey{}, err)
+}
+
+// reportStatuses reports the statuses, the Available condition is Unknown while the hub cluster is unreachable
+func (r *ObservabilityAddonReconciler) reportStatuses(ctx context.Context, obsAddon *oav1beta1.ObservabilityAddon,
+	s ...util.Status) {
+	if err, ok := ctx.Value(hubUnreachableKey{}).(error); ok {
+		s = append(s, util.Status{Type: "HubUnreachable", Details: err.Error()})
+	}
+	util.ReportStatuses(ctx, r.Client, obsAddon, s...)
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ObservabilityAddonReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if os.Getenv("NAMESPACE") != "" {
 		namespace = os.Getenv("NAMESPACE")
 	}
-	return ctrl.NewControllerManagedBy(mgr).
+	bldr := ctrl.NewControllerManagedBy(mgr)
+	if r.HubProvider != nil {
+		hubWatcher := newHubWatcher(r.HubProvider, mgr.GetScheme())
+		if err := mgr.Add(hubWatcher); err != nil {
+			return err
+		}
+		bldr = bldr.Watches(&source.Channel{Source: hubWatcher.events}, &handler.EnqueueRequestForObject{})
+	}
+	// the proxy is only watched in openshift
+	_, err := mgr.GetRESTMapper().RESTMapping(ocinfrav1.GroupVersion.WithKind("Proxy").GroupKind(),
+		ocinfrav1.GroupVersion.Version)
+	if err == nil {
+		bldr = bldr.Watches(&source.Kind{Type: &ocinfrav1.Proxy{}}, &handler.EnqueueRequestForObject{},
+			builder.WithPredicates(getPred(clusterProxyName, "", true, true, true)))
+	} else if !meta.IsNoMatchError(err) {
+		return err
+	}
+	if r.AppAllowlistCache != nil {
+		bldr = bldr.Watches(source.NewKindWithCache(&corev1.ConfigMap{}, r.AppAllowlistCache),
+			&handler.EnqueueRequestForObject{}, builder.WithPredicates(getAppAllowlistPred(namespace)))
+	}
+	return bldr.
 		For(&oav1beta1.ObservabilityAddon{}, builder.WithPredicates(getPred(obAddonName, namespace, true, true, true))).
 		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(hubConfigName, namespace, true, true, false))).
 		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(mtlsCertName, namespace, true, true, false))).
 		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(mtlsCaName, namespace, true, true, false))).
 		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(hubAmAccessorSecretName, namespace, true, true, false))).
-		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(metricsConfigMapName, namespace, true, true, false))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getAllowlistPred(namespace))).
 		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(caConfigmapName, namespace, false, true, true))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(endpointConfigName, namespace, true, true, true))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(kubeRootCAName, namespace, false, true, false))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(trustedCABundleName, namespace, false, true, true))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getConfiguredPred(namespace, r.getHubCABundleRef))).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(hubCABundleName, namespace, false, true, true))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(collectorConfigName, namespace, false, false, true))).
 		Watches(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(metricsCollectorName, namespace, true, true, true))).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(uwlMetricsCollectorName, namespace, true, true, true))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(uwlCollectorConfigName, namespace, false, false, true))).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getCollectorShardPred(namespace))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getCollectorShardPred(namespace))).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getCollectorPodPred(namespace))).
+		Watches(&source.Kind{Type: &policyv1.PodDisruptionBudget{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(metricsCollectorName, namespace, false, false, true))).
+		Watches(&source.Kind{Type: &policyv1.PodDisruptionBudget{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getCollectorShardPred(namespace))).
+		Watches(&source.Kind{Type: &rbacv1.RoleBinding{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(collectorLeaseRoleName, namespace, false, true, true))).
 		Watches(&source.Kind{Type: &rbacv1.ClusterRoleBinding{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(clusterRoleBindingName, "", false, true, true))).
+		Watches(&source.Kind{Type: &rbacv1.ClusterRoleBinding{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(uwlClusterRoleBindingName, "", false, true, true))).
 		Complete(r)
 }
 
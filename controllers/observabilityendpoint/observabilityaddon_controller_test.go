@@ -4,8 +4,10 @@ package observabilityendpoint
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	ocinfrav1 "github.com/openshift/api/config/v1"
 	appv1 "k8s.io/api/apps/v1"
@@ -16,10 +18,13 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	addonv1alpha1 "github.com/open-cluster-management/api/addon/v1alpha1"
+	"github.com/stolostron/endpoint-metrics-operator/pkg/util"
 	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
 	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
 )
@@ -91,6 +96,15 @@ func newClusterMonitoringConfigCM(configDataStr string) *corev1.ConfigMap {
 	}
 }
 
+// unreachableClient simulates the hub cluster which cannot be connected
+type unreachableClient struct {
+	client.Client
+}
+
+func (c unreachableClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return fmt.Errorf("dial tcp: connection refused")
+}
+
 func init() {
 	s := scheme.Scheme
 	addonv1alpha1.AddToScheme(s)
@@ -120,9 +134,11 @@ alertmanager-router-ca: |
 	hubClient := fake.NewFakeClient(hubObjs...)
 	c := fake.NewFakeClient(objs...)
 
+	recorder := record.NewFakeRecorder(100)
 	r := &ObservabilityAddonReconciler{
 		Client:    c,
 		HubClient: hubClient,
+		Recorder:  recorder,
 	}
 
 	// test error in reconcile if missing obervabilityaddon
@@ -193,6 +209,13 @@ alertmanager-router-ca: |
 	if err != nil {
 		t.Fatalf("Metrics collector deployment not created: (%v)", err)
 	}
+	events := drainEvents(recorder)
+	for _, reason := range []string{eventClusterRoleBindingCreated, eventCAConfigMapCreated,
+		eventMetricsCollectorCreated} {
+		if !hasEvent(events, corev1.EventTypeNormal, reason) {
+			t.Fatalf("Event %s not recorded: (%v)", reason, events)
+		}
+	}
 	foundOba := &oav1beta1.ObservabilityAddon{}
 	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName,
 		Namespace: hubNamespace}, foundOba)
@@ -202,6 +225,76 @@ alertmanager-router-ca: |
 	if !contains(foundOba.Finalizers, obsAddonFinalizer) {
 		t.Fatal("Finalizer not set in observabilityAddon")
 	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName,
+		Namespace: namespace}, foundOba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if cond := util.GetCondition(foundOba.Status.Conditions, util.ConditionProgressing); cond == nil ||
+		cond.Status != metav1.ConditionTrue {
+		t.Fatalf("Progressing condition not set before the collector is ready: (%v)", foundOba.Status)
+	}
+	if cond := util.GetCondition(foundOba.Status.Conditions, util.ConditionNotSupported); cond == nil ||
+		cond.Status != metav1.ConditionFalse {
+		t.Fatalf("NotSupported condition not flipped: (%v)", foundOba.Status)
+	}
+
+	// test the Available condition is set once the collector is ready
+	deploy.Status = appv1.DeploymentStatus{UpdatedReplicas: 1, ReadyReplicas: 1, AvailableReplicas: 1}
+	err = c.Status().Update(ctx, deploy)
+	if err != nil {
+		t.Fatalf("Failed to update the deployment status: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName,
+		Namespace: namespace}, foundOba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if cond := util.GetCondition(foundOba.Status.Conditions, util.ConditionAvailable); cond == nil ||
+		cond.Status != metav1.ConditionTrue {
+		t.Fatalf("Available condition not set: (%v)", foundOba.Status)
+	}
+
+	// test the Degraded condition is set if the collector container is crashing
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      metricsCollectorName + "-0",
+			Namespace: namespace,
+			Labels:    map[string]string{selectorKey: selectorValue},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: "metrics-collector",
+					State: corev1.ContainerState{
+						Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off restarting"},
+					},
+				},
+			},
+		},
+	}
+	err = c.Create(ctx, pod)
+	if err != nil {
+		t.Fatalf("Failed to create the metrics collector pod: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName,
+		Namespace: namespace}, foundOba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if cond := util.GetCondition(foundOba.Status.Conditions, util.ConditionDegraded); cond == nil ||
+		cond.Status != metav1.ConditionTrue || cond.Reason != "CrashLoopBackOff" {
+		t.Fatalf("Degraded condition not set for the crashing collector: (%v)", foundOba.Status)
+	}
+	c.Delete(ctx, pod)
 
 	// test reconcile w/o clusterversion(OCP 3.11)
 	c.Delete(ctx, cv)
@@ -317,3 +410,217 @@ alertmanager-router-ca: |
 		t.Fatal("Finalizer not removed from observabilityAddon")
 	}
 }
+
+func TestObservabilityAddonControllerKubernetes(t *testing.T) {
+	hubInfoData := []byte(`
+endpoint: "http://test-endpoint"
+alertmanager-endpoint: "http://test-alertamanger-endpoint"
+`)
+	hubObjs := []runtime.Object{newObservabilityAddon(name, testHubNamspace)}
+	objs := []runtime.Object{newHubInfoSecret(hubInfoData), newAMAccessorSecret(), getAllowlistCM(),
+		newObservabilityAddon(name, testNamespace), newKubeSystemNamespace(), newKubeRootCA("test-ca")}
+
+	hubClient := fake.NewFakeClient(hubObjs...)
+	c := fake.NewFakeClient(objs...)
+	r := &ObservabilityAddonReconciler{
+		Client:    c,
+		HubClient: hubClient,
+	}
+
+	// test reconcile w/o prometheus deployed by prometheus-operator
+	ctx := context.TODO()
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "install",
+			Namespace: testNamespace,
+		},
+	}
+	_, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	oba := &oav1beta1.ObservabilityAddon{}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: namespace}, oba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if cond := util.GetCondition(oba.Status.Conditions, util.ConditionNotSupported); cond == nil ||
+		cond.Status != metav1.ConditionTrue {
+		t.Fatalf("Status not set as NotSupported: (%v)", oba.Status)
+	}
+
+	// test reconcile successfully with prometheus deployed by prometheus-operator
+	err = c.Create(ctx, newPromOperatorSvc("prometheus-operated", "monitoring"))
+	if err != nil {
+		t.Fatalf("failed to create prometheus-operator svc: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	deploy := &appv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
+		Namespace: namespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not created: (%v)", err)
+	}
+	container := deploy.Spec.Template.Spec.Containers[0]
+	if container.Env[0].Value != "http://prometheus-operated.monitoring.svc:9090" {
+		t.Fatalf("Wrong metrics source: (%s)", container.Env[0].Value)
+	}
+	if !contains(container.Command, fmt.Sprintf("--label=\"clusterID=%s\"", testKubeSystemUID)) {
+		t.Fatalf("Wrong clusterID in command: (%v)", container.Command)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: caConfigmapName,
+		Namespace: namespace}, cm)
+	if err != nil || cm.Data["service-ca.crt"] != "test-ca" {
+		t.Fatalf("CA configmap not created from kube root CA: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName,
+		Namespace: promNamespace}, cm)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("cluster-monitoring-config should not be created in kubernetes cluster")
+	}
+}
+
+func TestObservabilityAddonControllerHubUnreachable(t *testing.T) {
+	hubInfoData := []byte(`
+endpoint: "http://test-endpoint"
+alertmanager-endpoint: "http://test-alertamanger-endpoint"
+`)
+	hubObjs := []runtime.Object{newObservabilityAddon(name, testHubNamspace)}
+	objs := []runtime.Object{newHubInfoSecret(hubInfoData), newAMAccessorSecret(), getAllowlistCM(),
+		newObservabilityAddon(name, testNamespace), newPromSvc(), cv, infra}
+
+	hubClient := fake.NewFakeClient(hubObjs...)
+	c := fake.NewFakeClient(objs...)
+	r := &ObservabilityAddonReconciler{
+		Client:       c,
+		HubClient:    unreachableClient{hubClient},
+		ResyncPeriod: 10 * time.Minute,
+		HubBackoff:   &util.Backoff{MinDelay: time.Second, MaxDelay: 4 * time.Second},
+	}
+	ctx := context.TODO()
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "install",
+			Namespace: testNamespace,
+		},
+	}
+
+	// test retry with backoff if the hub is unreachable and no hub observabilityaddon is known
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second} {
+		result, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatalf("reconcile: (%v)", err)
+		}
+		if result.RequeueAfter != expected {
+			t.Fatalf("Wrong retry delay, expected: %v, got: %v", expected, result.RequeueAfter)
+		}
+	}
+	deploy := &appv1.Deployment{}
+	err := c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: namespace}, deploy)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Metrics collector deployment created without the hub observabilityaddon: (%v)", err)
+	}
+
+	// test periodic resync once the hub is reachable
+	r.HubClient = hubClient
+	result, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	if result.RequeueAfter != r.ResyncPeriod {
+		t.Fatalf("Wrong resync period, expected: %v, got: %v", r.ResyncPeriod, result.RequeueAfter)
+	}
+
+	// test the local resources are still managed with the last known hub observabilityaddon
+	r.HubClient = unreachableClient{hubClient}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: namespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not created: (%v)", err)
+	}
+	err = c.Delete(ctx, deploy)
+	if err != nil {
+		t.Fatalf("Failed to delete metrics collector deployment: (%v)", err)
+	}
+	result, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	if result.RequeueAfter != time.Second {
+		t.Fatalf("Backoff not reset after the hub is reachable, got: %v", result.RequeueAfter)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: namespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not recreated while the hub is unreachable: (%v)", err)
+	}
+	foundOba := &oav1beta1.ObservabilityAddon{}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: namespace}, foundOba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if cond := util.GetCondition(foundOba.Status.Conditions, util.ConditionAvailable); cond == nil ||
+		cond.Status != metav1.ConditionUnknown || cond.Reason != "HubUnreachable" {
+		t.Fatalf("Available condition not Unknown while the hub is unreachable: (%v)", foundOba.Status)
+	}
+}
+
+func TestObservabilityAddonControllerHubDeletion(t *testing.T) {
+	hubInfoData := []byte(`
+endpoint: "http://test-endpoint"
+alertmanager-endpoint: "http://test-alertamanger-endpoint"
+`)
+	hubObjs := []runtime.Object{newObservabilityAddon(name, testHubNamspace)}
+	objs := []runtime.Object{newHubInfoSecret(hubInfoData), newAMAccessorSecret(), getAllowlistCM(),
+		newObservabilityAddon(name, testNamespace), newPromSvc(), cv, infra}
+
+	hubClient := fake.NewFakeClient(hubObjs...)
+	c := fake.NewFakeClient(objs...)
+	r := &ObservabilityAddonReconciler{
+		Client:    c,
+		HubClient: hubClient,
+	}
+	ctx := context.TODO()
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      obAddonName,
+			Namespace: testNamespace,
+		},
+	}
+	_, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	deploy := &appv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: namespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not created: (%v)", err)
+	}
+
+	// test the cleanup is done once the hub observabilityaddon is being deleted
+	hubObsAddon := &oav1beta1.ObservabilityAddon{}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: hubNamespace}, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	now := metav1.Now()
+	hubObsAddon.DeletionTimestamp = &now
+	err = hubClient.Update(ctx, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to update observabilityAddon: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: namespace}, deploy)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Metrics collector deployment not deleted: (%v)", err)
+	}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: hubNamespace}, hubObsAddon)
+	if err == nil && contains(hubObsAddon.Finalizers, obsAddonFinalizer) {
+		t.Fatal("Finalizer not removed from observabilityAddon")
+	}
+}
@@ -4,10 +4,8 @@ package observabilityendpoint
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"reflect"
-	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -15,7 +13,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	"github.com/ghodss/yaml"
 	cmomanifests "github.com/openshift/cluster-monitoring-operator/pkg/manifests"
 )
 
@@ -30,20 +27,25 @@ const (
 )
 
 // createHubAmRouterCASecret creates the secret that contains CA of the Hub's Alertmanager Route
-func createHubAmRouterCASecret(ctx context.Context, hubInfo *HubInfo, client client.Client) error {
+func createHubAmRouterCASecret(ctx context.Context, hubInfo *HubInfo, caBundle string, targetNamespace string,
+	client client.Client) error {
 	hubAmRouterCA := hubInfo.AlertmanagerRouterCA
+	if caBundle != "" {
+		// the router CA is combined with the additional CA bundle for the hub
+		hubAmRouterCA = combineCABundles(hubAmRouterCA, caBundle)
+	}
 	dataMap := map[string][]byte{hubAmRouterCASecretKey: []byte(hubAmRouterCA)}
 	hubAmRouterCASecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      hubAmRouterCASecretName,
-			Namespace: promNamespace,
+			Namespace: targetNamespace,
 		},
 		Data: dataMap,
 	}
 
 	found := &corev1.Secret{}
 	err := client.Get(ctx, types.NamespacedName{Name: hubAmRouterCASecretName,
-		Namespace: promNamespace}, found)
+		Namespace: targetNamespace}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = client.Create(ctx, hubAmRouterCASecret)
@@ -75,10 +77,10 @@ func createHubAmRouterCASecret(ctx context.Context, hubInfo *HubInfo, client cli
 }
 
 // deleteHubAmRouterCASecret deletes the secret that contains CA of the Hub's Alertmanager Route
-func deleteHubAmRouterCASecret(ctx context.Context, client client.Client) error {
+func deleteHubAmRouterCASecret(ctx context.Context, targetNamespace string, client client.Client) error {
 	found := &corev1.Secret{}
 	err := client.Get(ctx, types.NamespacedName{Name: hubAmRouterCASecretName,
-		Namespace: promNamespace}, found)
+		Namespace: targetNamespace}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("the hub-alertmanager-router-ca secret is already deleted")
@@ -97,7 +99,7 @@ func deleteHubAmRouterCASecret(ctx context.Context, client client.Client) error
 }
 
 // createHubAmAccessorTokenSecret creates the secret that contains access token of the Hub's Alertmanager
-func createHubAmAccessorTokenSecret(ctx context.Context, client client.Client) error {
+func createHubAmAccessorTokenSecret(ctx context.Context, targetNamespace string, client client.Client) error {
 	amAccessorToken, err := getAmAccessorToken(ctx, client)
 	if err != nil {
 		return fmt.Errorf("fail to get the alertmanager accessor token %v", err)
@@ -107,14 +109,14 @@ func createHubAmAccessorTokenSecret(ctx context.Context, client client.Client) e
 	hubAmAccessorTokenSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      hubAmAccessorSecretName,
-			Namespace: promNamespace,
+			Namespace: targetNamespace,
 		},
 		Data: dataMap,
 	}
 
 	found := &corev1.Secret{}
 	err = client.Get(ctx, types.NamespacedName{Name: hubAmAccessorSecretName,
-		Namespace: promNamespace}, found)
+		Namespace: targetNamespace}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = client.Create(ctx, hubAmAccessorTokenSecret)
@@ -146,10 +148,10 @@ func createHubAmAccessorTokenSecret(ctx context.Context, client client.Client) e
 }
 
 // deleteHubAmAccessorTokenSecret deletes the secret that contains access token of the Hub's Alertmanager
-func deleteHubAmAccessorTokenSecret(ctx context.Context, client client.Client) error {
+func deleteHubAmAccessorTokenSecret(ctx context.Context, targetNamespace string, client client.Client) error {
 	found := &corev1.Secret{}
 	err := client.Get(ctx, types.NamespacedName{Name: hubAmAccessorSecretName,
-		Namespace: promNamespace}, found)
+		Namespace: targetNamespace}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("the observability-alertmanager-accessor secret is already deleted")
@@ -184,288 +186,274 @@ func getAmAccessorToken(ctx context.Context, client client.Client) (string, erro
 }
 
 // createOrUpdateClusterMonitoringConfig creates or updates the configmap cluster-monitoring-config and relevant resources
-// (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift cluster monitoring stack
-func createOrUpdateClusterMonitoringConfig(ctx context.Context, hubInfo *HubInfo, clusterID string, client client.Client) error {
-	// create the hub-alertmanager-router-ca secret if it doesn't exist or update it if needed
-	if err := createHubAmRouterCASecret(ctx, hubInfo, client); err != nil {
-		log.Error(err, "failed to create or update the hub-alertmanager-router-ca secret")
-		return err
-	}
-
-	// create the observability-alertmanager-accessor secret if it doesn't exist or update it if needed
-	if err := createHubAmAccessorTokenSecret(ctx, client); err != nil {
-		log.Error(err, "failed to create or update the observability-alertmanager-accessor secret")
+// (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift cluster monitoring stack.
+// The same settings are injected into the user workload monitoring stack if it is enabled in the alert forwarding,
+// otherwise they are reverted from it.
+func createOrUpdateClusterMonitoringConfig(ctx context.Context, hubInfo *HubInfo, clusterID string,
+	forwarding AlertForwarding, client client.Client) error {
+	err := createOrUpdateMonitoringConfig(ctx, platformMonitoringStack, hubInfo, clusterID, forwarding, client)
+	if err != nil {
 		return err
 	}
-
-	// init the prometheus k8s config
-	newExternalLabels := map[string]string{clusterLabelKeyForAlerts: clusterID}
-	newAdditionalAlertmanagerConfig := cmomanifests.AdditionalAlertmanagerConfig{
-		Scheme:     "https",
-		PathPrefix: "/",
-		APIVersion: "v2",
-		TLSConfig: cmomanifests.TLSConfig{
-			CA: &corev1.SecretKeySelector{
-				LocalObjectReference: corev1.LocalObjectReference{
-					Name: hubAmRouterCASecretName,
-				},
-				Key: hubAmRouterCASecretKey,
-			},
-			InsecureSkipVerify: false,
-		},
-		BearerToken: &corev1.SecretKeySelector{
-			LocalObjectReference: corev1.LocalObjectReference{
-				Name: hubAmAccessorSecretName,
-			},
-			Key: hubAmAccessorSecretKey,
-		},
-		StaticConfigs: []string{strings.TrimLeft(hubInfo.AlertmanagerEndpoint, "https://")},
-	}
-	newAlertmanagerConfigs := []cmomanifests.AdditionalAlertmanagerConfig{newAdditionalAlertmanagerConfig}
-	newPmK8sConfig := &cmomanifests.PrometheusK8sConfig{
-		// add cluster label for alerts from managed cluster
-		ExternalLabels: newExternalLabels,
-		// add alertmanager configs
-		AlertmanagerConfigs: newAlertmanagerConfigs,
+	if forwarding.UserWorkload {
+		return createOrUpdateMonitoringConfig(ctx, userWorkloadMonitoringStack, hubInfo, clusterID, forwarding, client)
 	}
+	return revertMonitoringConfig(ctx, userWorkloadMonitoringStack, clusterID, client)
+}
 
-	// root for CMO configuration
-	newClusterMonitoringConfiguration := cmomanifests.ClusterMonitoringConfiguration{
-		PrometheusK8sConfig: newPmK8sConfig,
-	}
+// createOrUpdateMonitoringConfig creates or updates the configmap and relevant secrets for the monitoring stack.
+// The settings are merged into the existing config.yaml without touching the fields unknown to the operator,
+// and the injected settings are recorded in the annotation so that they can be reverted.
+func createOrUpdateMonitoringConfig(ctx context.Context, stack monitoringStack, hubInfo *HubInfo, clusterID string,
+	forwarding AlertForwarding, client client.Client) error {
+	if forwarding.isEnabled() {
+		// create the hub-alertmanager-router-ca secret if it doesn't exist or update it if needed
+		if err := createHubAmRouterCASecret(ctx, hubInfo, forwarding.caBundle, stack.namespace, client); err != nil {
+			log.Error(err, "failed to create or update the hub-alertmanager-router-ca secret")
+			return err
+		}
 
-	// marshal new CMO configuration to json then to yaml
-	newClusterMonitoringConfigurationJSONBytes, err := json.Marshal(newClusterMonitoringConfiguration)
-	if err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
-		return err
-	}
-	newClusterMonitoringConfigurationYAMLBytes, err := yaml.JSONToYAML(newClusterMonitoringConfigurationJSONBytes)
-	if err != nil {
-		log.Error(err, "failed to transform JSON to YAML", "JSON", newClusterMonitoringConfigurationJSONBytes)
-		return err
+		// create the observability-alertmanager-accessor secret if it doesn't exist or update it if needed
+		if err := createHubAmAccessorTokenSecret(ctx, stack.namespace, client); err != nil {
+			log.Error(err, "failed to create or update the observability-alertmanager-accessor secret")
+			return err
+		}
+	} else {
+		// the hub alertmanager config is removed from the configmap below
+		if err := deleteHubAmRouterCASecret(ctx, stack.namespace, client); err != nil {
+			log.Error(err, "failed to delete the hub-alertmanager-router-ca secret")
+			return err
+		}
+		if err := deleteHubAmAccessorTokenSecret(ctx, stack.namespace, client); err != nil {
+			log.Error(err, "failed to delete the observability-alertmanager-accessor secret")
+			return err
+		}
 	}
 
-	newCusterMonitoringConfigMap := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      clusterMonitoringConfigName,
-			Namespace: promNamespace,
-		},
-		Data: map[string]string{clusterMonitoringConfigDataKey: string(newClusterMonitoringConfigurationYAMLBytes)},
-	}
+	newAlertmanagerConfig := newHubAlertmanagerConfig(hubInfo, forwarding)
 
 	// try to retrieve the current configmap in the cluster
 	found := &corev1.ConfigMap{}
-	err = client.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName,
-		Namespace: promNamespace}, found)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			log.Info("configmap not found, try to create it", "name", clusterMonitoringConfigName)
-			err = client.Create(ctx, newCusterMonitoringConfigMap)
-			if err != nil {
-				log.Error(err, "failed to create configmap", "name", clusterMonitoringConfigName)
-				return err
-			}
-			log.Info("configmap created", "name", clusterMonitoringConfigName)
-			return nil
-		} else {
-			log.Error(err, "failed to check configmap", "name", clusterMonitoringConfigName)
+	err := client.Get(ctx, types.NamespacedName{Name: stack.configMapName,
+		Namespace: stack.namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "failed to check configmap", "name", stack.configMapName)
+		return err
+	}
+	if errors.IsNotFound(err) {
+		log.Info("configmap not found, try to create it", "name", stack.configMapName)
+		doc, _ := parseClusterMonitoringConfigNode("")
+		injected := &injectedClusterMonitoringConfig{}
+		if err := mergeMonitoringConfig(doc, injected, stack, clusterID, newAlertmanagerConfig); err != nil {
 			return err
 		}
-	}
-
-	log.Info("configmap already exists, check if it needs update", "name", clusterMonitoringConfigName)
-	foundClusterMonitoringConfigurationYAMLString, ok := found.Data[clusterMonitoringConfigDataKey]
-	if !ok {
-		log.Info("configmap data doesn't contain key, try to update it", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-		// replace config.yaml in configmap
-		found.Data[clusterMonitoringConfigDataKey] = string(newClusterMonitoringConfigurationYAMLBytes)
-		err = client.Update(ctx, found)
+		data, err := encodeClusterMonitoringConfigNode(doc)
+		if err != nil {
+			return err
+		}
+		newCusterMonitoringConfigMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      stack.configMapName,
+				Namespace: stack.namespace,
+			},
+		}
+		if err := setClusterMonitoringConfig(newCusterMonitoringConfigMap, data, injected); err != nil {
+			return err
+		}
+		err = client.Create(ctx, newCusterMonitoringConfigMap)
 		if err != nil {
-			log.Error(err, "failed to update configmap", "name", clusterMonitoringConfigName)
+			log.Error(err, "failed to create configmap", "name", stack.configMapName)
 			return err
 		}
-		log.Info("configmap updated", "name", clusterMonitoringConfigName)
+		log.Info("configmap created", "name", stack.configMapName)
+		recordNormal(ctx, eventClusterMonitoringConfigCreated, "Created the configmap %s/%s",
+			stack.namespace, stack.configMapName)
 		return nil
 	}
 
-	log.Info("configmap already exists and key config.yaml exists, check if the value needs update", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-	foundClusterMonitoringConfigurationJSONBytes, err := yaml.YAMLToJSON([]byte(foundClusterMonitoringConfigurationYAMLString))
+	log.Info("configmap already exists, check if it needs update", "name", stack.configMapName)
+	injected, err := getInjectedClusterMonitoringConfig(found)
 	if err != nil {
-		log.Error(err, "failed to transform YAML to JSON", "YAML", foundClusterMonitoringConfigurationYAMLString)
 		return err
 	}
-	foundClusterMonitoringConfiguration := &cmomanifests.ClusterMonitoringConfiguration{}
-	if err := json.Unmarshal([]byte(foundClusterMonitoringConfigurationJSONBytes), foundClusterMonitoringConfiguration); err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
+	foundConfigYAML, hasKey := found.Data[clusterMonitoringConfigDataKey]
+	doc, err := parseClusterMonitoringConfigNode(foundConfigYAML)
+	if err != nil {
 		return err
 	}
-
-	if foundClusterMonitoringConfiguration.PrometheusK8sConfig == nil {
-		foundClusterMonitoringConfiguration.PrometheusK8sConfig = newPmK8sConfig
-	} else {
-		// check if externalLabels exists
-		if foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels == nil {
-			foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels = newExternalLabels
-		} else {
-			foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels[clusterLabelKeyForAlerts] = clusterID
-		}
-
-		// check if alertmanagerConfigs exists
-		if foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs == nil {
-			foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs = newAlertmanagerConfigs
-		} else {
-			additionalAlertmanagerConfigExists := false
-			for _, v := range foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs {
-				if v.TLSConfig != (cmomanifests.TLSConfig{}) &&
-					v.TLSConfig.CA != nil &&
-					v.TLSConfig.CA.LocalObjectReference != (corev1.LocalObjectReference{}) &&
-					v.TLSConfig.CA.LocalObjectReference.Name == hubAmRouterCASecretName {
-					additionalAlertmanagerConfigExists = true
-					break
-				}
-			}
-			if !additionalAlertmanagerConfigExists {
-				foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs = append(foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs, newAdditionalAlertmanagerConfig)
-			}
-		}
+	if injected == nil {
+		// the settings injected by the former versions are not recorded
+		injected = legacyInjectedMonitoringConfig(doc, stack, clusterID)
 	}
-
-	// prepare to write back the cluster monitoring configuration
-	updatedClusterMonitoringConfigurationJSONBytes, err := json.Marshal(foundClusterMonitoringConfiguration)
-	if err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
+	if injected == nil {
+		injected = &injectedClusterMonitoringConfig{}
+	}
+	foundInjected := found.Annotations[clusterMonitoringConfigInjectedKey]
+	if err := mergeMonitoringConfig(doc, injected, stack, clusterID, newAlertmanagerConfig); err != nil {
 		return err
 	}
-	updatedclusterMonitoringConfigurationYAMLBytes, err := yaml.JSONToYAML(updatedClusterMonitoringConfigurationJSONBytes)
+	data, err := encodeClusterMonitoringConfigNode(doc)
 	if err != nil {
-		log.Error(err, "failed to transform JSON to YAML", "JSON", updatedClusterMonitoringConfigurationJSONBytes)
 		return err
 	}
-	found.Data[clusterMonitoringConfigDataKey] = string(updatedclusterMonitoringConfigurationYAMLBytes)
+	if err := setClusterMonitoringConfig(found, data, injected); err != nil {
+		return err
+	}
+	// skip the update if nothing is changed, so that the config.yaml of the admin is not rewritten
+	if hasKey && equalClusterMonitoringConfig(foundConfigYAML, data) &&
+		foundInjected == found.Annotations[clusterMonitoringConfigInjectedKey] {
+		log.Info("configmap is up to date", "name", stack.configMapName)
+		return nil
+	}
 	err = client.Update(ctx, found)
 	if err != nil {
-		log.Error(err, "failed to update configmap", "name", clusterMonitoringConfigName)
+		log.Error(err, "failed to update configmap", "name", stack.configMapName)
 		return err
 	}
-	log.Info("configmap updated", "name", clusterMonitoringConfigName)
+	log.Info("configmap updated", "name", stack.configMapName)
+	recordNormal(ctx, eventClusterMonitoringConfigMerged, "Merged the observability settings into the configmap %s/%s",
+		stack.namespace, stack.configMapName)
 	return nil
 }
 
+// newHubAlertmanagerConfig returns the additional alertmanager config for the hub alertmanager,
+// it returns nil if the alert forwarding is disabled
+func newHubAlertmanagerConfig(hubInfo *HubInfo, forwarding AlertForwarding) *cmomanifests.AdditionalAlertmanagerConfig {
+	if !forwarding.isEnabled() {
+		return nil
+	}
+	pathPrefix := forwarding.PathPrefix
+	if pathPrefix == "" {
+		pathPrefix = "/"
+	}
+	endpoints := forwarding.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{hubInfo.AlertmanagerEndpoint}
+	}
+	staticConfigs := []string{}
+	for _, endpoint := range endpoints {
+		staticConfigs = append(staticConfigs, alertmanagerHost(endpoint))
+	}
+	alertmanagerConfig := cmomanifests.AdditionalAlertmanagerConfig{
+		Scheme:     "https",
+		PathPrefix: pathPrefix,
+		APIVersion: "v2",
+		TLSConfig: cmomanifests.TLSConfig{
+			CA: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: hubAmRouterCASecretName,
+				},
+				Key: hubAmRouterCASecretKey,
+			},
+			InsecureSkipVerify: false,
+		},
+		BearerToken: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: hubAmAccessorSecretName,
+			},
+			Key: hubAmAccessorSecretKey,
+		},
+		StaticConfigs: staticConfigs,
+	}
+	if forwarding.Timeout != "" {
+		alertmanagerConfig.Timeout = &forwarding.Timeout
+	}
+	return &alertmanagerConfig
+}
+
 // revertClusterMonitoringConfig reverts the configmap cluster-monitoring-config and relevant resources
 // (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift cluster monitoring stack
-func revertClusterMonitoringConfig(ctx context.Context, client client.Client) error {
+// and the user workload monitoring stack
+func revertClusterMonitoringConfig(ctx context.Context, clusterID string, client client.Client) error {
+	if err := revertMonitoringConfig(ctx, platformMonitoringStack, clusterID, client); err != nil {
+		return err
+	}
+	return revertMonitoringConfig(ctx, userWorkloadMonitoringStack, clusterID, client)
+}
+
+// revertMonitoringConfig reverts the configmap and relevant secrets for the monitoring stack.
+// Only the settings recorded in the annotation are reverted, and the values set by the admin before are restored.
+// Without the record, the cluster label with the clusterID and the hub alertmanager config injected by the former
+// versions are reverted.
+// The configmap is deleted if it is empty after the revert, the configmap without the injected settings is not touched.
+func revertMonitoringConfig(ctx context.Context, stack monitoringStack, clusterID string, client client.Client) error {
 	// delete the hub-alertmanager-router-ca secret
-	if err := deleteHubAmRouterCASecret(ctx, client); err != nil {
+	if err := deleteHubAmRouterCASecret(ctx, stack.namespace, client); err != nil {
 		log.Error(err, "failed to delete the hub-alertmanager-router-ca secret")
 		return err
 	}
 
 	// delete the observability-alertmanager-accessor secret
-	if err := deleteHubAmAccessorTokenSecret(ctx, client); err != nil {
+	if err := deleteHubAmAccessorTokenSecret(ctx, stack.namespace, client); err != nil {
 		log.Error(err, "failed to delete the observability-alertmanager-accessor secret")
 		return err
 	}
 
 	// try to retrieve the current configmap in the cluster
 	found := &corev1.ConfigMap{}
-	err := client.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName,
-		Namespace: promNamespace}, found)
+	err := client.Get(ctx, types.NamespacedName{Name: stack.configMapName,
+		Namespace: stack.namespace}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("configmap not found, no need action", "name", clusterMonitoringConfigName)
+			log.Info("configmap not found, no need action", "name", stack.configMapName)
 			return nil
 		} else {
-			log.Error(err, "failed to check configmap", "name", clusterMonitoringConfigName)
+			log.Error(err, "failed to check configmap", "name", stack.configMapName)
 			return err
 		}
 	}
 
 	// revert the existing cluster-monitor-config configmap
-	log.Info("configmap exists, check if it needs revert", "name", clusterMonitoringConfigName)
-	foundClusterMonitoringConfigurationYAML, ok := found.Data[clusterMonitoringConfigDataKey]
-	if !ok {
-		log.Info("configmap data doesn't contain key, no need action", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-		return nil
-	}
-	foundClusterMonitoringConfigurationJSON, err := yaml.YAMLToJSON([]byte(foundClusterMonitoringConfigurationYAML))
+	log.Info("configmap exists, check if it needs revert", "name", stack.configMapName)
+	injected, err := getInjectedClusterMonitoringConfig(found)
 	if err != nil {
-		log.Error(err, "failed to transform YAML to JSON", "YAML", foundClusterMonitoringConfigurationYAML)
 		return err
 	}
-
-	log.Info("configmap exists and key config.yaml exists, check if the value needs revert", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-	foundClusterMonitoringConfiguration := &cmomanifests.ClusterMonitoringConfiguration{}
-	if err := json.Unmarshal([]byte(foundClusterMonitoringConfigurationJSON), foundClusterMonitoringConfiguration); err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
+	foundConfigYAML, ok := found.Data[clusterMonitoringConfigDataKey]
+	doc, err := parseClusterMonitoringConfigNode(foundConfigYAML)
+	if err != nil {
 		return err
 	}
-
-	if foundClusterMonitoringConfiguration.PrometheusK8sConfig == nil {
-		log.Info("configmap data doesn't key: prometheusK8s, no need action", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
+	if injected == nil {
+		injected = legacyInjectedMonitoringConfig(doc, stack, clusterID)
+	}
+	if injected == nil {
+		log.Info("configmap doesn't contain the observability settings, no need action", "name", stack.configMapName)
 		return nil
-	} else {
-		// check if externalLabels exists
-		if foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels != nil {
-			if _, ok := foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels[clusterLabelKeyForAlerts]; ok {
-				delete(foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels, clusterLabelKeyForAlerts)
-			}
-			if len(foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels) == 0 {
-				foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels = nil
-			}
-		}
-
-		// check if alertmanagerConfigs exists
-		if foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs != nil {
-			copiedAlertmanagerConfigs := make([]cmomanifests.AdditionalAlertmanagerConfig, 0)
-			for _, v := range foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs {
-				if v.TLSConfig == (cmomanifests.TLSConfig{}) ||
-					v.TLSConfig.CA == nil ||
-					v.TLSConfig.CA.LocalObjectReference == (corev1.LocalObjectReference{}) ||
-					v.TLSConfig.CA.LocalObjectReference.Name != hubAmRouterCASecretName {
-					copiedAlertmanagerConfigs = append(copiedAlertmanagerConfigs, v)
-				}
-			}
-			if len(copiedAlertmanagerConfigs) == 0 {
-				foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs = nil
-				if reflect.DeepEqual(*foundClusterMonitoringConfiguration.PrometheusK8sConfig, cmomanifests.PrometheusK8sConfig{}) {
-					foundClusterMonitoringConfiguration.PrometheusK8sConfig = nil
-				}
-			} else {
-				foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs = copiedAlertmanagerConfigs
-			}
-		}
+	}
+	revertInjectedMonitoringConfig(doc, injected, stack)
+	data, err := encodeClusterMonitoringConfigNode(doc)
+	if err != nil {
+		return err
 	}
 
-	// check if the foundClusterMonitoringConfiguration is empty ClusterMonitoringConfiguration
-	if reflect.DeepEqual(*foundClusterMonitoringConfiguration, cmomanifests.ClusterMonitoringConfiguration{}) {
-		log.Info("empty ClusterMonitoringConfiguration, should delete configmap", "name", clusterMonitoringConfigName)
+	// check if the config is empty after the revert
+	if len(doc.Content[0].Content) == 0 && len(found.Data) <= 1 {
+		log.Info("empty ClusterMonitoringConfiguration, should delete configmap", "name", stack.configMapName)
 		err = client.Delete(ctx, found)
 		if err != nil {
-			log.Error(err, "failed to delete configmap", "name", clusterMonitoringConfigName)
+			log.Error(err, "failed to delete configmap", "name", stack.configMapName)
 			return err
 		}
-		log.Info("configmap delete", "name", clusterMonitoringConfigName)
+		log.Info("configmap delete", "name", stack.configMapName)
+		recordNormal(ctx, eventClusterMonitoringConfigReverted, "Deleted the configmap %s/%s since it is empty after the revert",
+			stack.namespace, stack.configMapName)
 		return nil
 	}
 
-	// prepare to write back the cluster monitoring configuration
-	updatedClusterMonitoringConfigurationJSONBytes, err := json.Marshal(foundClusterMonitoringConfiguration)
-	if err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
-		return err
-	}
-	updatedClusterMonitoringConfigurationYAMLBytes, err := yaml.JSONToYAML(updatedClusterMonitoringConfigurationJSONBytes)
-	if err != nil {
-		log.Error(err, "failed to transform JSON to YAML", "JSON", updatedClusterMonitoringConfigurationJSONBytes)
+	if !ok {
+		// only the record of the injected settings is left
+		delete(found.Annotations, clusterMonitoringConfigInjectedKey)
+	} else if err := setClusterMonitoringConfig(found, data, nil); err != nil {
 		return err
 	}
-	found.Data[clusterMonitoringConfigDataKey] = string(updatedClusterMonitoringConfigurationYAMLBytes)
 	err = client.Update(ctx, found)
 	if err != nil {
-		log.Error(err, "failed to update configmap", "name", clusterMonitoringConfigName)
+		log.Error(err, "failed to update configmap", "name", stack.configMapName)
 		return err
 	}
-	log.Info("configmap updated", "name", clusterMonitoringConfigName)
+	log.Info("configmap updated", "name", stack.configMapName)
+	recordNormal(ctx, eventClusterMonitoringConfigReverted, "Reverted the observability settings in the configmap %s/%s",
+		stack.namespace, stack.configMapName)
 	return nil
 }
@@ -0,0 +1,395 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ghodssyaml "github.com/ghodss/yaml"
+	cmomanifests "github.com/openshift/cluster-monitoring-operator/pkg/manifests"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// the annotation on the cluster-monitoring-config configmap recording the injected settings
+	clusterMonitoringConfigInjectedKey = "observability.open-cluster-management.io/injected-config"
+
+	prometheusK8sKey                 = "prometheusK8s"
+	externalLabelsKey                = "externalLabels"
+	additionalAlertmanagerConfigsKey = "additionalAlertManagerConfigs"
+
+	userWorkloadMonitoringConfigName = "user-workload-monitoring-config"
+	userWorkloadMonitoringNamespace  = "openshift-user-workload-monitoring"
+	// the keys in the config.yaml of the user workload monitoring stack
+	userWorkloadPrometheusKey                    = "prometheus"
+	userWorkloadAdditionalAlertmanagerConfigsKey = "additionalAlertmanagerConfigs"
+)
+
+// monitoringStack is the openshift monitoring stack which the alerts are forwarded to the hub alertmanager from
+type monitoringStack struct {
+	// configMapName is the configmap of the stack in the namespace, the secrets are created in the namespace too
+	configMapName string
+	namespace     string
+	// prometheusKey is the key of the prometheus config in the config.yaml
+	prometheusKey string
+	// alertmanagerConfigsKey is the key of the additional alertmanager configs in the prometheus config
+	alertmanagerConfigsKey string
+}
+
+var (
+	platformMonitoringStack = monitoringStack{
+		configMapName:          clusterMonitoringConfigName,
+		namespace:              promNamespace,
+		prometheusKey:          prometheusK8sKey,
+		alertmanagerConfigsKey: additionalAlertmanagerConfigsKey,
+	}
+	userWorkloadMonitoringStack = monitoringStack{
+		configMapName:          userWorkloadMonitoringConfigName,
+		namespace:              userWorkloadMonitoringNamespace,
+		prometheusKey:          userWorkloadPrometheusKey,
+		alertmanagerConfigsKey: userWorkloadAdditionalAlertmanagerConfigsKey,
+	}
+)
+
+// injectedClusterMonitoringConfig records the settings injected into the configmap of the monitoring stack,
+// so that the revert removes exactly them and restores the values set by the admin before
+type injectedClusterMonitoringConfig struct {
+	// ExternalLabels maps the injected external labels to the prior values, the value is nil if the label was not set
+	ExternalLabels map[string]*string `json:"externalLabels,omitempty"`
+	// AlertmanagerConfig is true if the additional alertmanager config for the hub alertmanager is injected
+	AlertmanagerConfig bool `json:"alertmanagerConfig,omitempty"`
+}
+
+// getInjectedClusterMonitoringConfig returns the injected settings recorded in the configmap,
+// it returns nil if there is no record
+func getInjectedClusterMonitoringConfig(cm *corev1.ConfigMap) (*injectedClusterMonitoringConfig, error) {
+	data, ok := cm.Annotations[clusterMonitoringConfigInjectedKey]
+	if !ok || data == "" {
+		return nil, nil
+	}
+	injected := &injectedClusterMonitoringConfig{}
+	if err := json.Unmarshal([]byte(data), injected); err != nil {
+		log.Error(err, "failed to unmarshal the injected settings", "name", clusterMonitoringConfigName)
+		return nil, err
+	}
+	return injected, nil
+}
+
+// parseClusterMonitoringConfig parses the config.yaml into the generic map to compare the settings,
+// the numbers are kept as they are instead of converting them to float64
+func parseClusterMonitoringConfig(data string) (map[string]interface{}, error) {
+	jsonBytes, err := ghodssyaml.YAMLToJSON([]byte(data))
+	if err != nil {
+		log.Error(err, "failed to transform YAML to JSON", "YAML", data)
+		return nil, err
+	}
+	config := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		log.Error(err, "failed to unmarshal the cluster monitoring config")
+		return nil, err
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return config, nil
+}
+
+// equalClusterMonitoringConfig checks if the two config.yaml have the same settings regardless of the formatting
+func equalClusterMonitoringConfig(a, b string) bool {
+	configA, err := parseClusterMonitoringConfig(a)
+	if err != nil {
+		return false
+	}
+	configB, err := parseClusterMonitoringConfig(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(configA, configB)
+}
+
+// parseClusterMonitoringConfigNode parses the config.yaml into the yaml document node which is edited in place,
+// so that the comments, the order of the keys and the fields unknown to the operator are kept as they are
+func parseClusterMonitoringConfigNode(data string) (*yaml.Node, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(data), doc); err != nil {
+		log.Error(err, "failed to unmarshal the cluster monitoring config")
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		doc.Content[0] = newMappingNode()
+	}
+	return doc, nil
+}
+
+// encodeClusterMonitoringConfigNode encodes the yaml document node into the config.yaml
+func encodeClusterMonitoringConfigNode(doc *yaml.Node) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		log.Error(err, "failed to marshal the cluster monitoring config")
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// setClusterMonitoringConfig writes the config.yaml and the record of the injected settings into the configmap,
+// the record is removed if injected is nil
+func setClusterMonitoringConfig(cm *corev1.ConfigMap, data string, injected *injectedClusterMonitoringConfig) error {
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[clusterMonitoringConfigDataKey] = data
+
+	if injected == nil {
+		delete(cm.Annotations, clusterMonitoringConfigInjectedKey)
+		return nil
+	}
+	injectedBytes, err := json.Marshal(injected)
+	if err != nil {
+		log.Error(err, "failed to marshal the injected settings")
+		return err
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	cm.Annotations[clusterMonitoringConfigInjectedKey] = string(injectedBytes)
+	return nil
+}
+
+// legacyInjectedMonitoringConfig returns the settings injected by the former versions of the operator, which didn't
+// record them: the cluster label set to the clusterID and the hub alertmanager config.
+// It returns nil if none of them is in the config document.
+func legacyInjectedMonitoringConfig(doc *yaml.Node, stack monitoringStack,
+	clusterID string) *injectedClusterMonitoringConfig {
+	pmK8sConfig := childMapping(doc.Content[0], stack.prometheusKey, false)
+	injected := &injectedClusterMonitoringConfig{}
+	externalLabels := childMapping(pmK8sConfig, externalLabelsKey, false)
+	if value := mappingValue(externalLabels, clusterLabelKeyForAlerts); value != nil &&
+		value.Kind == yaml.ScalarNode && value.Value == clusterID {
+		injected.ExternalLabels = map[string]*string{clusterLabelKeyForAlerts: nil}
+	}
+	if alertmanagerConfigs := mappingValue(pmK8sConfig, stack.alertmanagerConfigsKey); alertmanagerConfigs != nil &&
+		alertmanagerConfigs.Kind == yaml.SequenceNode {
+		for _, c := range alertmanagerConfigs.Content {
+			if isHubAlertmanagerConfig(c) {
+				injected.AlertmanagerConfig = true
+			}
+		}
+	}
+	if injected.ExternalLabels == nil && !injected.AlertmanagerConfig {
+		return nil
+	}
+	return injected
+}
+
+// mergeMonitoringConfig injects the cluster label and the hub alertmanager config into the config document,
+// the prior values of the injected labels are recorded in injected.
+// The hub alertmanager config is removed if alertmanagerConfig is nil.
+func mergeMonitoringConfig(doc *yaml.Node, injected *injectedClusterMonitoringConfig, stack monitoringStack,
+	clusterID string, alertmanagerConfig *cmomanifests.AdditionalAlertmanagerConfig) error {
+	pmK8sConfig := childMapping(doc.Content[0], stack.prometheusKey, true)
+
+	externalLabels := childMapping(pmK8sConfig, externalLabelsKey, true)
+	if injected.ExternalLabels == nil {
+		injected.ExternalLabels = map[string]*string{}
+	}
+	if _, ok := injected.ExternalLabels[clusterLabelKeyForAlerts]; !ok {
+		var prior *string
+		if value := mappingValue(externalLabels, clusterLabelKeyForAlerts); value != nil &&
+			value.Kind == yaml.ScalarNode {
+			priorValue := value.Value
+			prior = &priorValue
+		}
+		injected.ExternalLabels[clusterLabelKeyForAlerts] = prior
+	}
+	setMappingString(externalLabels, clusterLabelKeyForAlerts, clusterID)
+
+	if alertmanagerConfig == nil {
+		removeHubAlertmanagerConfig(pmK8sConfig, stack.alertmanagerConfigsKey)
+		injected.AlertmanagerConfig = false
+		return nil
+	}
+	alertmanagerConfigNode, err := toYAMLNode(alertmanagerConfig)
+	if err != nil {
+		log.Error(err, "failed to marshal the additional alertmanager config")
+		return err
+	}
+	alertmanagerConfigs := mappingValue(pmK8sConfig, stack.alertmanagerConfigsKey)
+	if alertmanagerConfigs == nil || alertmanagerConfigs.Kind != yaml.SequenceNode {
+		alertmanagerConfigs = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMappingValue(pmK8sConfig, stack.alertmanagerConfigsKey, alertmanagerConfigs)
+	}
+	replaced := false
+	for i, c := range alertmanagerConfigs.Content {
+		if isHubAlertmanagerConfig(c) {
+			alertmanagerConfigs.Content[i] = alertmanagerConfigNode
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		alertmanagerConfigs.Content = append(alertmanagerConfigs.Content, alertmanagerConfigNode)
+	}
+	injected.AlertmanagerConfig = true
+	return nil
+}
+
+// revertInjectedMonitoringConfig removes the injected settings from the config document and restores the prior values,
+// the emptied fields are removed as well
+func revertInjectedMonitoringConfig(doc *yaml.Node, injected *injectedClusterMonitoringConfig,
+	stack monitoringStack) {
+	config := doc.Content[0]
+	pmK8sConfig := childMapping(config, stack.prometheusKey, false)
+	if pmK8sConfig == nil {
+		return
+	}
+
+	if externalLabels := childMapping(pmK8sConfig, externalLabelsKey, false); externalLabels != nil {
+		for key, prior := range injected.ExternalLabels {
+			if prior == nil {
+				deleteMappingValue(externalLabels, key)
+			} else {
+				setMappingString(externalLabels, key, *prior)
+			}
+		}
+		if len(externalLabels.Content) == 0 {
+			deleteMappingValue(pmK8sConfig, externalLabelsKey)
+		}
+	}
+
+	if injected.AlertmanagerConfig {
+		removeHubAlertmanagerConfig(pmK8sConfig, stack.alertmanagerConfigsKey)
+	}
+
+	if len(pmK8sConfig.Content) == 0 {
+		deleteMappingValue(config, stack.prometheusKey)
+	}
+}
+
+// removeHubAlertmanagerConfig removes the hub alertmanager config from the additional alertmanager configs
+// under the key of the prometheus config, the key is removed if it is empty
+func removeHubAlertmanagerConfig(pmK8sConfig *yaml.Node, alertmanagerConfigsKey string) {
+	alertmanagerConfigs := mappingValue(pmK8sConfig, alertmanagerConfigsKey)
+	if alertmanagerConfigs == nil || alertmanagerConfigs.Kind != yaml.SequenceNode {
+		return
+	}
+	copiedAlertmanagerConfigs := []*yaml.Node{}
+	for _, c := range alertmanagerConfigs.Content {
+		if !isHubAlertmanagerConfig(c) {
+			copiedAlertmanagerConfigs = append(copiedAlertmanagerConfigs, c)
+		}
+	}
+	if len(copiedAlertmanagerConfigs) == 0 {
+		deleteMappingValue(pmK8sConfig, alertmanagerConfigsKey)
+	} else {
+		alertmanagerConfigs.Content = copiedAlertmanagerConfigs
+	}
+}
+
+// isHubAlertmanagerConfig checks if the additional alertmanager config refers to the hub alertmanager router CA
+func isHubAlertmanagerConfig(c *yaml.Node) bool {
+	ca := childMapping(childMapping(c, "tlsConfig", false), "ca", false)
+	name := mappingValue(ca, "name")
+	return name != nil && name.Value == hubAmRouterCASecretName
+}
+
+// childMapping returns the mapping node under the key of the parent,
+// the mapping node is created if it doesn't exist and create is true
+func childMapping(parent *yaml.Node, key string, create bool) *yaml.Node {
+	child := mappingValue(parent, key)
+	if child != nil && child.Kind == yaml.MappingNode {
+		return child
+	}
+	if parent == nil || parent.Kind != yaml.MappingNode || !create {
+		return nil
+	}
+	child = newMappingNode()
+	setMappingValue(parent, key, child)
+	return child
+}
+
+// mappingValue returns the value node under the key of the mapping node, it returns nil if the key doesn't exist
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets the value node under the key of the mapping node, the key is appended if it doesn't exist
+func setMappingValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// setMappingString sets the string under the key of the mapping node,
+// the existing scalar node is updated in place to keep its comments
+func setMappingString(m *yaml.Node, key, value string) {
+	if found := mappingValue(m, key); found != nil && found.Kind == yaml.ScalarNode {
+		if found.Value != value || found.Tag != "!!str" {
+			found.Value, found.Tag, found.Style = value, "!!str", 0
+		}
+		return
+	}
+	setMappingValue(m, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// deleteMappingValue removes the key and its value from the mapping node
+func deleteMappingValue(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func newMappingNode() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+// toYAMLNode converts the typed object into the yaml node via json, so that the json field names of the CMO types are used
+func toYAMLNode(obj interface{}) (*yaml.Node, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	node := doc.Content[0]
+	resetStyle(node)
+	return node, nil
+}
+
+// resetStyle resets the flow style decoded from json to the block style of the config.yaml
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
@@ -0,0 +1,462 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	adminClusterMonitoringConfig = `
+enableUserWorkload: true
+unknownComponent:
+  replicas: 2
+prometheusK8s:
+  retention: 24h
+  unknownField: keep-me
+  externalLabels:
+    cluster: admin-cluster
+    env: prod
+`
+)
+
+func TestMergeRevertClusterMonitoringConfig(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	c := fake.NewFakeClient(newHubInfoSecret([]byte(hubInfoYAML)), newAMAccessorSecret(),
+		newClusterMonitoringConfigCM(adminClusterMonitoringConfig))
+	ctx := context.TODO()
+	adminConfig, err := parseClusterMonitoringConfig(adminClusterMonitoringConfig)
+	if err != nil {
+		t.Fatalf("Failed to parse the admin config: (%v)", err)
+	}
+
+	err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, AlertForwarding{}, c)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	found := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, found)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	config, err := parseClusterMonitoringConfig(found.Data[clusterMonitoringConfigDataKey])
+	if err != nil {
+		t.Fatalf("Failed to parse the merged config: (%v)", err)
+	}
+	if config["enableUserWorkload"] != true || childMap(config, "unknownComponent") == nil {
+		t.Fatalf("Unknown fields not kept: (%v)", config)
+	}
+	pmK8sConfig := childMap(config, prometheusK8sKey)
+	if pmK8sConfig["unknownField"] != "keep-me" || pmK8sConfig["retention"] != "24h" {
+		t.Fatalf("Unknown fields in prometheusK8s not kept: (%v)", pmK8sConfig)
+	}
+	externalLabels := childMap(pmK8sConfig, externalLabelsKey)
+	if externalLabels[clusterLabelKeyForAlerts] != testClusterID || externalLabels["env"] != "prod" {
+		t.Fatalf("Wrong external labels: (%v)", externalLabels)
+	}
+	injected, err := getInjectedClusterMonitoringConfig(found)
+	if err != nil || injected == nil {
+		t.Fatalf("Injected settings not recorded: (%v)", err)
+	}
+	if prior := injected.ExternalLabels[clusterLabelKeyForAlerts]; prior == nil || *prior != "admin-cluster" {
+		t.Fatalf("Prior cluster label not recorded: (%v)", injected.ExternalLabels)
+	}
+
+	// test the configmap is not updated if nothing is changed
+	resourceVersion := found.ResourceVersion
+	err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, AlertForwarding{}, c)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, found)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if found.ResourceVersion != resourceVersion {
+		t.Fatal("The cluster-monitoring-config configmap is updated without changes")
+	}
+
+	// test the admin config is restored after the revert
+	err = revertClusterMonitoringConfig(ctx, testClusterID, c)
+	if err != nil {
+		t.Fatalf("Failed to revert cluster-monitoring-config configmap: (%v)", err)
+	}
+	found = &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, found)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	config, err = parseClusterMonitoringConfig(found.Data[clusterMonitoringConfigDataKey])
+	if err != nil {
+		t.Fatalf("Failed to parse the reverted config: (%v)", err)
+	}
+	if !reflect.DeepEqual(config, adminConfig) {
+		t.Fatalf("Admin config not restored, expected: %v, got: %v", adminConfig, config)
+	}
+	if _, ok := found.Annotations[clusterMonitoringConfigInjectedKey]; ok {
+		t.Fatal("Injected settings record not removed after the revert")
+	}
+}
+
+// formerClusterMonitoringConfig is written by the former versions, which merged the cluster label and the hub
+// alertmanager config into the admin settings without recording them
+const formerClusterMonitoringConfig = `
+prometheusK8s:
+  additionalAlertManagerConfigs:
+  - apiVersion: v2
+    bearerToken:
+      key: token
+      name: observability-alertmanager-accessor
+    pathPrefix: /
+    scheme: https
+    staticConfigs:
+    - test-alertamanger-endpoint
+    tlsConfig:
+      ca:
+        key: service-ca.crt
+        name: hub-alertmanager-router-ca
+      insecureSkipVerify: false
+  externalLabels:
+    cluster: ` + testClusterID + `
+    env: prod
+  retention: 24h
+`
+
+func TestUpgradeFromFormerVersion(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	ctx := context.TODO()
+	expected, err := parseClusterMonitoringConfig("prometheusK8s:\n  externalLabels:\n    env: prod\n  retention: 24h\n")
+	if err != nil {
+		t.Fatalf("Failed to parse the expected config: (%v)", err)
+	}
+	checkReverted := func(c client.Client) {
+		found := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, found)
+		if err != nil {
+			t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+		}
+		config, err := parseClusterMonitoringConfig(found.Data[clusterMonitoringConfigDataKey])
+		if err != nil {
+			t.Fatalf("Failed to parse the reverted config: (%v)", err)
+		}
+		if !reflect.DeepEqual(config, expected) {
+			t.Fatalf("Settings injected by the former version not reverted, expected: %v, got: %v", expected, config)
+		}
+	}
+
+	// the addon is deleted before the first reconcile of the new version
+	c := fake.NewFakeClient(newHubInfoSecret([]byte(hubInfoYAML)), newAMAccessorSecret(),
+		newClusterMonitoringConfigCM(formerClusterMonitoringConfig))
+	if err := revertClusterMonitoringConfig(ctx, testClusterID, c); err != nil {
+		t.Fatalf("Failed to revert cluster-monitoring-config configmap: (%v)", err)
+	}
+	checkReverted(c)
+
+	// the cluster label injected by the former version is not recorded as the prior value of the admin
+	c = fake.NewFakeClient(newHubInfoSecret([]byte(hubInfoYAML)), newAMAccessorSecret(),
+		newClusterMonitoringConfigCM(formerClusterMonitoringConfig))
+	err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, AlertForwarding{}, c)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	found := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, found)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	injected, err := getInjectedClusterMonitoringConfig(found)
+	if err != nil || injected == nil || !injected.AlertmanagerConfig {
+		t.Fatalf("Injected settings not recorded: %v, (%v)", injected, err)
+	}
+	if prior, ok := injected.ExternalLabels[clusterLabelKeyForAlerts]; !ok || prior != nil {
+		t.Fatalf("Cluster label of the former version recorded as the admin value: (%v)", injected.ExternalLabels)
+	}
+	config, err := parseClusterMonitoringConfig(found.Data[clusterMonitoringConfigDataKey])
+	if err != nil {
+		t.Fatalf("Failed to parse the merged config: (%v)", err)
+	}
+	if n := len(childMap(config, prometheusK8sKey)[additionalAlertmanagerConfigsKey].([]interface{})); n != 1 {
+		t.Fatalf("Hub alertmanager config duplicated: (%v)", config)
+	}
+	if err := revertClusterMonitoringConfig(ctx, testClusterID, c); err != nil {
+		t.Fatalf("Failed to revert cluster-monitoring-config configmap: (%v)", err)
+	}
+	checkReverted(c)
+}
+
+func TestMergeKeepsAdminComments(t *testing.T) {
+	adminConfig := `# the retention is required by the audit
+prometheusK8s:
+  retention: 24h # keep it
+  externalLabels:
+    env: prod
+enableUserWorkload: true
+`
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	c := fake.NewFakeClient(newHubInfoSecret([]byte(hubInfoYAML)), newAMAccessorSecret(),
+		newClusterMonitoringConfigCM(adminConfig))
+	ctx := context.TODO()
+	getData := func() string {
+		found := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, found)
+		if err != nil {
+			t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+		}
+		return found.Data[clusterMonitoringConfigDataKey]
+	}
+
+	err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, AlertForwarding{}, c)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	data := getData()
+	for _, comment := range []string{"# the retention is required by the audit", "retention: 24h # keep it"} {
+		if !strings.Contains(data, comment) {
+			t.Fatalf("Comment %q not kept in the merged config: (%s)", comment, data)
+		}
+	}
+	if strings.Index(data, prometheusK8sKey) > strings.Index(data, "enableUserWorkload") {
+		t.Fatalf("Order of the keys not kept in the merged config: (%s)", data)
+	}
+
+	err = revertClusterMonitoringConfig(ctx, testClusterID, c)
+	if err != nil {
+		t.Fatalf("Failed to revert cluster-monitoring-config configmap: (%v)", err)
+	}
+	if data := getData(); data != adminConfig {
+		t.Fatalf("Admin config not restored as it was, expected: %s, got: %s", adminConfig, data)
+	}
+}
+
+func TestConfigurableAlertForwarding(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	c := fake.NewFakeClient(newHubInfoSecret([]byte(hubInfoYAML)), newAMAccessorSecret())
+	ctx := context.TODO()
+	getPmK8sConfig := func() map[string]interface{} {
+		found := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, found)
+		if err != nil {
+			t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+		}
+		config, err := parseClusterMonitoringConfig(found.Data[clusterMonitoringConfigDataKey])
+		if err != nil {
+			t.Fatalf("Failed to parse the config: (%v)", err)
+		}
+		return childMap(config, prometheusK8sKey)
+	}
+
+	forwarding := AlertForwarding{
+		Timeout:    "30s",
+		PathPrefix: "/alertmanager",
+		Endpoints:  []string{"https://alertmanager-0.hub.example.com", "alertmanager-1.hub.example.com:443"},
+		ProxyURL:   "http://proxy.example.com:3128",
+	}
+	err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, forwarding, c)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	pmK8sConfig := getPmK8sConfig()
+	alertmanagerConfigs, _ := pmK8sConfig[additionalAlertmanagerConfigsKey].([]interface{})
+	if len(alertmanagerConfigs) != 1 {
+		t.Fatalf("Wrong number of alertmanager configs: (%v)", alertmanagerConfigs)
+	}
+	amConfig := asMap(alertmanagerConfigs[0])
+	expectedStaticConfigs := []interface{}{"alertmanager-0.hub.example.com", "alertmanager-1.hub.example.com:443"}
+	// the proxy is not applied since the additional alertmanager config has no proxy setting
+	if _, ok := amConfig["proxyURL"]; ok {
+		t.Fatalf("Unsupported proxyURL set in the hub alertmanager config: (%v)", amConfig)
+	}
+	if amConfig["timeout"] != "30s" || amConfig["pathPrefix"] != "/alertmanager" ||
+		!reflect.DeepEqual(amConfig["staticConfigs"], expectedStaticConfigs) {
+		t.Fatalf("Wrong hub alertmanager config: (%v)", amConfig)
+	}
+
+	// test only the hub alertmanager config and its secrets are removed when the forwarding is disabled
+	adminAlertmanagerConfig := map[string]interface{}{"apiVersion": "v2", "staticConfigs": []interface{}{"am.example.com"}}
+	found := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, found)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	pmK8sConfig[additionalAlertmanagerConfigsKey] = append(alertmanagerConfigs, adminAlertmanagerConfig)
+	data, err := yaml.Marshal(map[string]interface{}{prometheusK8sKey: pmK8sConfig})
+	if err != nil {
+		t.Fatalf("Failed to marshal the config: (%v)", err)
+	}
+	injected, _ := getInjectedClusterMonitoringConfig(found)
+	if err := setClusterMonitoringConfig(found, string(data), injected); err != nil {
+		t.Fatalf("Failed to set the config: (%v)", err)
+	}
+	if err := c.Update(ctx, found); err != nil {
+		t.Fatalf("Failed to update the cluster-monitoring-config configmap: (%v)", err)
+	}
+
+	disabled := false
+	err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, AlertForwarding{Enabled: &disabled}, c)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	pmK8sConfig = getPmK8sConfig()
+	alertmanagerConfigs, _ = pmK8sConfig[additionalAlertmanagerConfigsKey].([]interface{})
+	if len(alertmanagerConfigs) != 1 || !reflect.DeepEqual(alertmanagerConfigs[0], adminAlertmanagerConfig) {
+		t.Fatalf("Only the hub alertmanager config should be removed: (%v)", alertmanagerConfigs)
+	}
+	if childMap(pmK8sConfig, externalLabelsKey)[clusterLabelKeyForAlerts] != testClusterID {
+		t.Fatalf("Cluster label should be kept: (%v)", pmK8sConfig)
+	}
+	for _, name := range []string{hubAmRouterCASecretName, hubAmAccessorSecretName} {
+		err = c.Get(ctx, types.NamespacedName{Name: name, Namespace: promNamespace}, &corev1.Secret{})
+		if !errors.IsNotFound(err) {
+			t.Fatalf("The secret %s should be deleted: (%v)", name, err)
+		}
+	}
+}
+
+func TestUserWorkloadAlertForwarding(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	uwmConfigMap := newClusterMonitoringConfigCM("prometheus:\n  retention: 24h\n")
+	uwmConfigMap.Name = userWorkloadMonitoringConfigName
+	uwmConfigMap.Namespace = userWorkloadMonitoringNamespace
+	c := fake.NewFakeClient(newHubInfoSecret([]byte(hubInfoYAML)), newAMAccessorSecret(), uwmConfigMap)
+	ctx := context.TODO()
+	getConfig := func() map[string]interface{} {
+		found := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: userWorkloadMonitoringConfigName,
+			Namespace: userWorkloadMonitoringNamespace}, found)
+		if err != nil {
+			t.Fatalf("Failed to get the user-workload-monitoring-config configmap: (%v)", err)
+		}
+		config, err := parseClusterMonitoringConfig(found.Data[clusterMonitoringConfigDataKey])
+		if err != nil {
+			t.Fatalf("Failed to parse the config: (%v)", err)
+		}
+		return config
+	}
+	checkSecrets := func(exist bool) {
+		for _, name := range []string{hubAmRouterCASecretName, hubAmAccessorSecretName} {
+			err = c.Get(ctx, types.NamespacedName{Name: name, Namespace: userWorkloadMonitoringNamespace}, &corev1.Secret{})
+			if exist && err != nil {
+				t.Fatalf("The secret %s should be created: (%v)", name, err)
+			}
+			if !exist && !errors.IsNotFound(err) {
+				t.Fatalf("The secret %s should be deleted: (%v)", name, err)
+			}
+		}
+	}
+
+	err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, AlertForwarding{UserWorkload: true}, c)
+	if err != nil {
+		t.Fatalf("Failed to create or update the monitoring configmaps: (%v)", err)
+	}
+	pmConfig := childMap(getConfig(), userWorkloadPrometheusKey)
+	if pmConfig["retention"] != "24h" ||
+		childMap(pmConfig, externalLabelsKey)[clusterLabelKeyForAlerts] != testClusterID {
+		t.Fatalf("Wrong prometheus config for the user workload: (%v)", pmConfig)
+	}
+	alertmanagerConfigs, _ := pmConfig[userWorkloadAdditionalAlertmanagerConfigsKey].([]interface{})
+	ca := childMap(childMap(asMap(alertmanagerConfigs[0]), "tlsConfig"), "ca")
+	if len(alertmanagerConfigs) != 1 || ca["name"] != hubAmRouterCASecretName {
+		t.Fatalf("Hub alertmanager config not injected for the user workload: (%v)", pmConfig)
+	}
+	checkSecrets(true)
+
+	// test the user workload monitoring stack is reverted when it is not enabled
+	err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, AlertForwarding{}, c)
+	if err != nil {
+		t.Fatalf("Failed to create or update the monitoring configmaps: (%v)", err)
+	}
+	expectedConfig, _ := parseClusterMonitoringConfig("prometheus:\n  retention: 24h\n")
+	if config := getConfig(); !reflect.DeepEqual(config, expectedConfig) {
+		t.Fatalf("User workload config not reverted, expected: %v, got: %v", expectedConfig, config)
+	}
+	checkSecrets(false)
+	err = c.Get(ctx, types.NamespacedName{Name: hubAmRouterCASecretName, Namespace: promNamespace}, &corev1.Secret{})
+	if err != nil {
+		t.Fatalf("The secret for the platform monitoring stack should be kept: (%v)", err)
+	}
+}
+
+// childMap returns the map under the key of the parsed config, it returns nil if there is no such map
+func childMap(parent map[string]interface{}, key string) map[string]interface{} {
+	return asMap(parent[key])
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func TestRevertKeepsAdminConfigMap(t *testing.T) {
+	uwmConfigMap := newClusterMonitoringConfigCM("")
+	uwmConfigMap.Name = userWorkloadMonitoringConfigName
+	uwmConfigMap.Namespace = userWorkloadMonitoringNamespace
+	c := fake.NewFakeClient(uwmConfigMap)
+	ctx := context.TODO()
+	key := types.NamespacedName{Name: userWorkloadMonitoringConfigName, Namespace: userWorkloadMonitoringNamespace}
+	found := &corev1.ConfigMap{}
+	if err := c.Get(ctx, key, found); err != nil {
+		t.Fatalf("Failed to get the user-workload-monitoring-config configmap: (%v)", err)
+	}
+	resourceVersion := found.ResourceVersion
+
+	// the empty configmap created by the admin is not touched without the injected settings
+	for i := 0; i < 2; i++ {
+		if err := revertMonitoringConfig(ctx, userWorkloadMonitoringStack, testClusterID, c); err != nil {
+			t.Fatalf("Failed to revert the user-workload-monitoring-config configmap: (%v)", err)
+		}
+	}
+	if err := c.Get(ctx, key, found); err != nil {
+		t.Fatalf("The user-workload-monitoring-config configmap created by the admin is deleted: (%v)", err)
+	}
+	if found.ResourceVersion != resourceVersion {
+		t.Fatal("The user-workload-monitoring-config configmap is updated without the injected settings")
+	}
+
+	// the settings of the admin are kept after the injected settings are reverted
+	found.Data = map[string]string{clusterMonitoringConfigDataKey: "prometheus:\n  retention: 24h\n"}
+	found.Annotations = map[string]string{clusterMonitoringConfigInjectedKey: `{"alertmanagerConfig":true}`}
+	if err := c.Update(ctx, found); err != nil {
+		t.Fatalf("Failed to update the user-workload-monitoring-config configmap: (%v)", err)
+	}
+	if err := revertMonitoringConfig(ctx, userWorkloadMonitoringStack, testClusterID, c); err != nil {
+		t.Fatalf("Failed to revert the user-workload-monitoring-config configmap: (%v)", err)
+	}
+	found = &corev1.ConfigMap{}
+	if err := c.Get(ctx, key, found); err != nil {
+		t.Fatalf("The user-workload-monitoring-config configmap created by the admin is deleted: (%v)", err)
+	}
+	if _, ok := found.Annotations[clusterMonitoringConfigInjectedKey]; ok {
+		t.Fatal("Injected settings record not removed after the revert")
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"net/url"
+
+	ocinfrav1 "github.com/openshift/api/config/v1"
+	"golang.org/x/net/http/httpproxy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	clusterProxyName = "cluster"
+	// the configmap the cluster network operator injects the trusted CA bundle into, including the CA of the proxy
+	trustedCABundleName        = "metrics-collector-trusted-ca-bundle"
+	trustedCABundleKey         = "ca-bundle.crt"
+	injectTrustedCABundleLabel = "config.openshift.io/inject-trusted-cabundle"
+	trustedCAVolName           = "trusted-ca-bundle"
+	// the trusted CA bundle is mounted beside the system trust store of the metrics collector image,
+	// which is kept until the bundle is injected
+	trustedCAMountPath = "/etc/pki/ca-trust/trusted-ca-bundle"
+	trustedCAFile      = "tls-ca-bundle.pem"
+	// the env of the go runtime to load the certificates in the directory in addition to the system trust store
+	trustedCADirEnv = "SSL_CERT_DIR"
+)
+
+// clusterProxy is the cluster-wide proxy of openshift, which is used to reach the hub
+type clusterProxy struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+	// TrustedCA is true if the proxy has the additional CA bundle
+	TrustedCA bool
+}
+
+// getClusterProxy returns the cluster-wide proxy, it is nil if no proxy is configured
+func getClusterProxy(ctx context.Context, c client.Client) (*clusterProxy, error) {
+	proxy := &ocinfrav1.Proxy{}
+	err := c.Get(ctx, types.NamespacedName{Name: clusterProxyName}, proxy)
+	if err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		log.Error(err, "Failed to get the cluster proxy")
+		return nil, err
+	}
+	p := &clusterProxy{
+		HTTPProxy:  proxy.Status.HTTPProxy,
+		HTTPSProxy: proxy.Status.HTTPSProxy,
+		NoProxy:    proxy.Status.NoProxy,
+		TrustedCA:  proxy.Spec.TrustedCA.Name != "",
+	}
+	if p.HTTPProxy == "" && p.HTTPSProxy == "" && !p.TrustedCA {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// proxyURL returns the proxy for the https host, it is empty if the host is in the no proxy list
+func (p *clusterProxy) proxyURL(host string) string {
+	if p == nil {
+		return ""
+	}
+	config := httpproxy.Config{HTTPProxy: p.HTTPProxy, HTTPSProxy: p.HTTPSProxy, NoProxy: p.NoProxy}
+	u, err := config.ProxyFunc()(&url.URL{Scheme: "https", Host: host})
+	if err != nil || u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+// apply sets the proxy env of the metrics collector container, and mounts the trusted CA bundle
+// into the directory trusted by the metrics collector if the proxy has the additional CA bundle
+func (p *clusterProxy) apply(spec *corev1.PodSpec) {
+	if p == nil {
+		return
+	}
+	container := &spec.Containers[0]
+	for _, env := range []corev1.EnvVar{
+		{Name: "HTTP_PROXY", Value: p.HTTPProxy},
+		{Name: "HTTPS_PROXY", Value: p.HTTPSProxy},
+		{Name: "NO_PROXY", Value: p.NoProxy},
+	} {
+		if env.Value != "" {
+			container.Env = append(container.Env, env)
+		}
+	}
+	if !p.TrustedCA {
+		return
+	}
+	optional := true
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: trustedCAVolName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: trustedCABundleName,
+				},
+				Items: []corev1.KeyToPath{
+					{
+						Key:  trustedCABundleKey,
+						Path: trustedCAFile,
+					},
+				},
+				// the bundle is injected after the configmap is created
+				Optional: &optional,
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      trustedCAVolName,
+		MountPath: trustedCAMountPath,
+		ReadOnly:  true,
+	})
+	// the directory is empty until the bundle is injected, the default CA file of the image is still loaded
+	container.Env = append(container.Env, corev1.EnvVar{Name: trustedCADirEnv, Value: trustedCAMountPath})
+}
+
+// withClusterProxy returns the alert forwarding using the cluster proxy if no proxy is configured for it
+// and any of the hub alertmanager endpoints is not in the no proxy list
+func withClusterProxy(forwarding AlertForwarding, hubInfo HubInfo, proxy *clusterProxy) AlertForwarding {
+	if forwarding.ProxyURL != "" || proxy == nil {
+		return forwarding
+	}
+	endpoints := forwarding.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{hubInfo.AlertmanagerEndpoint}
+	}
+	for _, endpoint := range endpoints {
+		if proxyURL := proxy.proxyURL(alertmanagerHost(endpoint)); proxyURL != "" {
+			forwarding.ProxyURL = proxyURL
+			break
+		}
+	}
+	return forwarding
+}
+
+// updateTrustedCABundle creates the configmap for the trusted CA bundle if it is injected,
+// otherwise the configmap is deleted
+func updateTrustedCABundle(ctx context.Context, c client.Client, inject bool) error {
+	if !inject {
+		return deleteTrustedCABundle(ctx, c)
+	}
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: trustedCABundleName, Namespace: namespace}, found)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "Failed to check the trusted CA bundle configmap", "name", trustedCABundleName)
+			return err
+		}
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      trustedCABundleName,
+				Namespace: namespace,
+				Labels: map[string]string{
+					injectTrustedCABundleLabel: "true",
+				},
+				Annotations: map[string]string{
+					ownerLabelKey: ownerLabelValue,
+				},
+			},
+		}
+		err = c.Create(ctx, cm)
+		if err != nil {
+			log.Error(err, "Failed to create the trusted CA bundle configmap", "name", trustedCABundleName)
+			return err
+		}
+		log.Info("trusted CA bundle configmap created", "name", trustedCABundleName)
+		return nil
+	}
+	// the data is owned by the cluster network operator, only the label is restored
+	if found.Labels[injectTrustedCABundleLabel] == "true" {
+		return nil
+	}
+	if found.Labels == nil {
+		found.Labels = map[string]string{}
+	}
+	found.Labels[injectTrustedCABundleLabel] = "true"
+	err = c.Update(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to update the trusted CA bundle configmap", "name", trustedCABundleName)
+		return err
+	}
+	return nil
+}
+
+// deleteTrustedCABundle deletes the configmap for the trusted CA bundle
+func deleteTrustedCABundle(ctx context.Context, c client.Client) error {
+	return deleteIfExists(ctx, c, types.NamespacedName{Name: trustedCABundleName, Namespace: namespace},
+		&corev1.ConfigMap{})
+}
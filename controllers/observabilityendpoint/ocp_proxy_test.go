@@ -0,0 +1,140 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	ocinfrav1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+func newClusterProxy(trustedCA string) *ocinfrav1.Proxy {
+	return &ocinfrav1.Proxy{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterProxyName},
+		Spec: ocinfrav1.ProxySpec{
+			TrustedCA: ocinfrav1.ConfigMapNameReference{Name: trustedCA},
+		},
+		Status: ocinfrav1.ProxyStatus{
+			HTTPProxy:  "http://proxy.example.com:3128",
+			HTTPSProxy: "http://proxy.example.com:3129",
+			NoProxy:    ".cluster.local,.svc,.internal.example.com",
+		},
+	}
+}
+
+func TestClusterProxy(t *testing.T) {
+	ctx := context.TODO()
+	proxy, err := getClusterProxy(ctx, fake.NewFakeClient())
+	if err != nil || proxy != nil {
+		t.Fatalf("Wrong cluster proxy without the proxy: (%v), (%v)", proxy, err)
+	}
+	proxy, err = getClusterProxy(ctx, fake.NewFakeClient(&ocinfrav1.Proxy{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterProxyName},
+	}))
+	if err != nil || proxy != nil {
+		t.Fatalf("Wrong cluster proxy without the proxy configured: (%v), (%v)", proxy, err)
+	}
+	proxy, err = getClusterProxy(ctx, fake.NewFakeClient(newClusterProxy("user-ca-bundle")))
+	if err != nil {
+		t.Fatalf("Failed to get the cluster proxy: (%v)", err)
+	}
+	if proxy.HTTPSProxy != "http://proxy.example.com:3129" || !proxy.TrustedCA {
+		t.Fatalf("Wrong cluster proxy: (%v)", proxy)
+	}
+
+	// the proxy env and the trusted CA bundle are set in the metrics collector, the overrides take precedence
+	config := EndpointConfig{
+		clusterProxy: proxy,
+		CollectorPod: CollectorPod{Env: []corev1.EnvVar{{Name: "NO_PROXY", Value: ".example.com"}}},
+	}
+	spec := createDeployment(testClusterID, "", oashared.ObservabilityAddonSpec{}, HubInfo{}, config, "", 1).
+		Spec.Template.Spec
+	env := map[string]string{}
+	for _, e := range spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["HTTP_PROXY"] != proxy.HTTPProxy || env["HTTPS_PROXY"] != proxy.HTTPSProxy || env["NO_PROXY"] != ".example.com" {
+		t.Fatalf("Wrong proxy env: (%v)", spec.Containers[0].Env)
+	}
+	mounted := false
+	for _, m := range spec.Containers[0].VolumeMounts {
+		mounted = mounted || (m.Name == trustedCAVolName && m.MountPath == trustedCAMountPath)
+	}
+	if !mounted {
+		t.Fatalf("The trusted CA bundle is not mounted: (%v)", spec.Containers[0].VolumeMounts)
+	}
+	if env[trustedCADirEnv] != trustedCAMountPath {
+		t.Fatalf("The metrics collector is not pointed at the trusted CA bundle: (%v)", spec.Containers[0].Env)
+	}
+
+	caseList := []struct {
+		forwarding AlertForwarding
+		hubInfo    HubInfo
+		proxyURL   string
+	}{
+		{AlertForwarding{}, HubInfo{AlertmanagerEndpoint: "https://alertmanager.hub.example.com"},
+			"http://proxy.example.com:3129"},
+		{AlertForwarding{}, HubInfo{AlertmanagerEndpoint: "https://alertmanager.internal.example.com"}, ""},
+		{AlertForwarding{Endpoints: []string{"alertmanager.internal.example.com:443", "alertmanager.hub.example.com:443"}},
+			HubInfo{}, "http://proxy.example.com:3129"},
+		{AlertForwarding{ProxyURL: "http://alerts-proxy.example.com:3128"},
+			HubInfo{AlertmanagerEndpoint: "https://alertmanager.hub.example.com"}, "http://alerts-proxy.example.com:3128"},
+	}
+	for _, c := range caseList {
+		forwarding := withClusterProxy(c.forwarding, c.hubInfo, proxy)
+		if forwarding.ProxyURL != c.proxyURL {
+			t.Errorf("Wrong proxy for alert forwarding %v: (%s)", c.forwarding, forwarding.ProxyURL)
+		}
+	}
+	if withClusterProxy(AlertForwarding{}, caseList[0].hubInfo, nil).ProxyURL != "" {
+		t.Fatalf("Proxy set for alert forwarding without the cluster proxy")
+	}
+}
+
+func TestUpdateTrustedCABundle(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+	err := updateTrustedCABundle(ctx, c, true)
+	if err != nil {
+		t.Fatalf("Failed to create the trusted CA bundle configmap: (%v)", err)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: trustedCABundleName, Namespace: namespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the trusted CA bundle configmap: (%v)", err)
+	}
+	if cm.Labels[injectTrustedCABundleLabel] != "true" {
+		t.Fatalf("No inject label in the trusted CA bundle configmap: (%v)", cm.Labels)
+	}
+
+	// the injected bundle is kept
+	cm.Data = map[string]string{trustedCABundleKey: "test-bundle"}
+	if err := c.Update(ctx, cm); err != nil {
+		t.Fatalf("Failed to update the trusted CA bundle configmap: (%v)", err)
+	}
+	err = updateTrustedCABundle(ctx, c, true)
+	if err != nil {
+		t.Fatalf("Failed to update the trusted CA bundle configmap: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: trustedCABundleName, Namespace: namespace}, cm)
+	if err != nil || cm.Data[trustedCABundleKey] != "test-bundle" {
+		t.Fatalf("The injected bundle is not kept: (%v), (%v)", cm.Data, err)
+	}
+
+	err = updateTrustedCABundle(ctx, c, false)
+	if err != nil {
+		t.Fatalf("Failed to delete the trusted CA bundle configmap: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: trustedCABundleName, Namespace: namespace}, &corev1.ConfigMap{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("The trusted CA bundle configmap is not deleted")
+	}
+}
@@ -19,8 +19,9 @@ import (
 )
 
 const (
-	clusterRoleBindingName = "metrics-collector-view"
-	caConfigmapName        = "metrics-collector-serving-certs-ca-bundle"
+	clusterRoleBindingName    = "metrics-collector-view"
+	monitoringViewClusterRole = "cluster-monitoring-view"
+	caConfigmapName           = "metrics-collector-serving-certs-ca-bundle"
 )
 
 var (
@@ -28,72 +29,88 @@ var (
 )
 
 func deleteMonitoringClusterRoleBinding(ctx context.Context, client client.Client) error {
+	return deleteClusterRoleBinding(ctx, client, clusterRoleBindingName)
+}
+
+func createMonitoringClusterRoleBinding(ctx context.Context, client client.Client) error {
+	return createClusterRoleBinding(ctx, client, clusterRoleBindingName, monitoringViewClusterRole, serviceAccountName)
+}
+
+// deleteClusterRoleBinding deletes the clusterrolebinding with the name
+func deleteClusterRoleBinding(ctx context.Context, client client.Client, name string) error {
 	rb := &rbacv1.ClusterRoleBinding{}
-	err := client.Get(ctx, types.NamespacedName{Name: clusterRoleBindingName,
+	err := client.Get(ctx, types.NamespacedName{Name: name,
 		Namespace: ""}, rb)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("clusterrolebinding already deleted")
+			log.Info("clusterrolebinding already deleted", "name", name)
 			return nil
 		}
-		log.Error(err, "Failed to check the clusterrolebinding")
+		log.Error(err, "Failed to check the clusterrolebinding", "name", name)
 		return err
 	}
 	err = client.Delete(ctx, rb)
 	if err != nil {
-		log.Error(err, "Error deleting clusterrolebinding")
+		log.Error(err, "Error deleting clusterrolebinding", "name", name)
 		return err
 	}
-	log.Info("clusterrolebinding deleted")
+	log.Info("clusterrolebinding deleted", "name", name)
+	recordNormal(ctx, eventClusterRoleBindingDeleted, "Deleted the clusterrolebinding %s", name)
 	return nil
 }
 
-func createMonitoringClusterRoleBinding(ctx context.Context, client client.Client) error {
+// createClusterRoleBinding creates or updates the clusterrolebinding with the name,
+// which binds the clusterrole to the service account in the namespace of the operator
+func createClusterRoleBinding(ctx context.Context, client client.Client, name string, clusterRole string,
+	sa string) error {
 	rb := &rbacv1.ClusterRoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: clusterRoleBindingName,
+			Name: name,
 			Annotations: map[string]string{
 				ownerLabelKey: ownerLabelValue,
 			},
 		},
 		RoleRef: rbacv1.RoleRef{
 			Kind:     "ClusterRole",
-			Name:     "cluster-monitoring-view",
+			Name:     clusterRole,
 			APIGroup: "rbac.authorization.k8s.io",
 		},
 		Subjects: []rbacv1.Subject{
 			{
 				Kind:      "ServiceAccount",
-				Name:      serviceAccountName,
+				Name:      sa,
 				Namespace: namespace,
 			},
 		},
 	}
 
 	found := &rbacv1.ClusterRoleBinding{}
-	err := client.Get(ctx, types.NamespacedName{Name: clusterRoleBindingName,
+	err := client.Get(ctx, types.NamespacedName{Name: name,
 		Namespace: ""}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = client.Create(ctx, rb)
 			if err == nil {
-				log.Info("clusterrolebinding created")
+				log.Info("clusterrolebinding created", "name", name)
+				recordNormal(ctx, eventClusterRoleBindingCreated, "Created the clusterrolebinding %s", name)
 			} else {
-				log.Error(err, "Failed to create the clusterrolebinding")
+				log.Error(err, "Failed to create the clusterrolebinding", "name", name)
 			}
 			return err
 		}
-		log.Error(err, "Failed to check the clusterrolebinding")
+		log.Error(err, "Failed to check the clusterrolebinding", "name", name)
 		return err
 	}
 
 	if reflect.DeepEqual(rb.RoleRef, found.RoleRef) && reflect.DeepEqual(rb.Subjects, found.Subjects) {
-		log.Info("The clusterrolebinding already existed")
+		log.Info("The clusterrolebinding already existed", "name", name)
 	} else {
 		rb.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
 		err = client.Update(ctx, rb)
 		if err != nil {
-			log.Error(err, "Failed to update the clusterrolebinding")
+			log.Error(err, "Failed to update the clusterrolebinding", "name", name)
+		} else {
+			recordNormal(ctx, eventClusterRoleBindingUpdated, "Updated the clusterrolebinding %s", name)
 		}
 	}
 
@@ -118,6 +135,7 @@ func deleteCAConfigmap(ctx context.Context, client client.Client) error {
 		return err
 	}
 	log.Info("configmap deleted")
+	recordNormal(ctx, eventCAConfigMapDeleted, "Deleted the configmap %s", caConfigmapName)
 	return nil
 }
 
@@ -141,6 +159,7 @@ func createCAConfigmap(ctx context.Context, client client.Client) error {
 			err = client.Create(ctx, cm)
 			if err == nil {
 				log.Info("Configmap created")
+				recordNormal(ctx, eventCAConfigMapCreated, "Created the configmap %s", caConfigmapName)
 			} else {
 				log.Error(err, "Failed to create the configmap")
 			}
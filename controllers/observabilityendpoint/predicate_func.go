@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
@@ -40,8 +42,11 @@ func getPred(name string, namespace string,
 				// also check objectNew string in case Kind is empty
 				if strings.HasPrefix(fmt.Sprint(e.ObjectNew), "&Deployment") ||
 					e.ObjectNew.GetObjectKind().GroupVersionKind().Kind == "Deployment" {
+					// the status is also checked so that the addon status follows the rollout of the deployment
 					if !reflect.DeepEqual(e.ObjectNew.(*v1.Deployment).Spec.Template.Spec,
-						e.ObjectOld.(*v1.Deployment).Spec.Template.Spec) {
+						e.ObjectOld.(*v1.Deployment).Spec.Template.Spec) ||
+						!reflect.DeepEqual(e.ObjectNew.(*v1.Deployment).Status,
+							e.ObjectOld.(*v1.Deployment).Status) {
 						return true
 					}
 				} else if e.ObjectNew.GetName() == obAddonName ||
@@ -71,3 +76,104 @@ func getPred(name string, namespace string,
 		DeleteFunc: deleteFunc,
 	}
 }
+
+// getAllowlistPred returns the predicate for the configmaps contributing to the metrics allowlist
+func getAllowlistPred(namespace string) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return e.Object.GetNamespace() == namespace &&
+				isAllowlistConfigMap(e.Object.GetName(), e.Object.GetLabels())
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectNew.GetNamespace() != namespace ||
+				e.ObjectNew.GetResourceVersion() == e.ObjectOld.GetResourceVersion() {
+				return false
+			}
+			// the label may be removed from a configmap which was an allowlist before
+			return isAllowlistConfigMap(e.ObjectNew.GetName(), e.ObjectNew.GetLabels()) ||
+				isAllowlistConfigMap(e.ObjectOld.GetName(), e.ObjectOld.GetLabels())
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return e.Object.GetNamespace() == namespace &&
+				isAllowlistConfigMap(e.Object.GetName(), e.Object.GetLabels())
+		},
+	}
+}
+
+// getConfiguredPred filters the objects in the namespace with the name configured at runtime,
+// nothing is filtered in if the name is empty
+func getConfiguredPred(namespace string, name func() string) predicate.Funcs {
+	matches := func(obj client.Object) bool {
+		n := name()
+		return n != "" && obj.GetNamespace() == namespace && obj.GetName() == n
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return matches(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return matches(e.ObjectNew) && e.ObjectNew.GetResourceVersion() != e.ObjectOld.GetResourceVersion()
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return matches(e.Object)
+		},
+	}
+}
+
+// getAppAllowlistPred returns the predicate for the labeled allowlist configmaps in the application namespaces
+func getAppAllowlistPred(namespace string) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return e.Object.GetNamespace() != namespace
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectNew.GetNamespace() != namespace &&
+				e.ObjectNew.GetResourceVersion() != e.ObjectOld.GetResourceVersion()
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return e.Object.GetNamespace() != namespace
+		},
+	}
+}
+
+// getCollectorShardPred returns the predicate for the deployments and configmaps of the metrics collector shards
+// other than the first one
+func getCollectorShardPred(namespace string) predicate.Funcs {
+	isShard := func(name string) bool {
+		_, isDeployment := parseShardIndex(name, metricsCollectorName)
+		_, isConfig := parseShardIndex(name, collectorConfigName)
+		return isDeployment || isConfig
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectNew.GetNamespace() == namespace && isShard(e.ObjectNew.GetName()) &&
+				e.ObjectNew.GetResourceVersion() != e.ObjectOld.GetResourceVersion()
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return e.Object.GetNamespace() == namespace && isShard(e.Object.GetName())
+		},
+	}
+}
+
+// getCollectorPodPred returns the predicate for the container state changes of the metrics collector pods
+func getCollectorPodPred(namespace string) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectNew.GetNamespace() != namespace ||
+				!isCollectorComponent(e.ObjectNew.GetLabels()[selectorKey]) {
+				return false
+			}
+			return !reflect.DeepEqual(e.ObjectNew.(*corev1.Pod).Status.ContainerStatuses,
+				e.ObjectOld.(*corev1.Pod).Status.ContainerStatuses)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+	}
+}
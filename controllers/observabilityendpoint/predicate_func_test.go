@@ -126,6 +126,10 @@ func TestPredFunc(t *testing.T) {
 				if pred.UpdateFunc(ue) {
 					t.Fatalf("pre func return true on same deployment spec in case: (%v)", c.caseName)
 				}
+				ue.ObjectNew.(*appsv1.Deployment).Status.ReadyReplicas = 1
+				if !pred.UpdateFunc(ue) {
+					t.Fatalf("pre func return false on changed deployment status in case: (%v)", c.caseName)
+				}
 			} else {
 				if pred.UpdateFunc(ue) {
 					t.Fatalf("pre func return true on non-applied updateevent in case: (%v)", c.caseName)
@@ -159,3 +163,34 @@ func TestPredFunc(t *testing.T) {
 		})
 	}
 }
+
+func TestCollectorPodPred(t *testing.T) {
+	pred := getCollectorPodPred(testNamespace)
+	newPod := func(reason string) *v1.Pod {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      metricsCollectorName + "-0",
+				Namespace: testNamespace,
+				Labels:    map[string]string{selectorKey: selectorValue},
+			},
+		}
+		if reason != "" {
+			pod.Status.ContainerStatuses = []v1.ContainerStatus{
+				{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}}},
+			}
+		}
+		return pod
+	}
+
+	if !pred.UpdateFunc(event.UpdateEvent{ObjectOld: newPod(""), ObjectNew: newPod("CrashLoopBackOff")}) {
+		t.Fatal("pod pred return false on changed container state")
+	}
+	if pred.UpdateFunc(event.UpdateEvent{ObjectOld: newPod(""), ObjectNew: newPod("")}) {
+		t.Fatal("pod pred return true on same container state")
+	}
+	other := newPod("CrashLoopBackOff")
+	other.Labels = nil
+	if pred.UpdateFunc(event.UpdateEvent{ObjectOld: newPod(""), ObjectNew: other}) {
+		t.Fatal("pod pred return true on non metrics collector pod")
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+const (
+	uwlMetricsCollectorName   = "uwl-metrics-collector-deployment"
+	uwlCollectorConfigName    = "uwl-metrics-collector-config"
+	uwlSelectorValue          = "uwl-metrics-collector"
+	uwlServiceAccountName     = "uwl-metrics-collector"
+	uwlClusterRoleName        = "uwl-metrics-collector-view"
+	uwlClusterRoleBindingName = "uwl-metrics-collector-view"
+	// uwlPrometheusName is the name of the prometheus resource of the user workload monitoring stack
+	uwlPrometheusName = "user-workload"
+)
+
+var uwlCollector = collectorKind{
+	name:               uwlMetricsCollectorName,
+	configName:         uwlCollectorConfigName,
+	component:          uwlSelectorValue,
+	serviceAccountName: uwlServiceAccountName,
+}
+
+// updateUWLMetricsCollector runs the collector for the metrics in the userWorkload section of the allowlist
+// if the user workload metrics are enabled, otherwise the collector and its RBAC are deleted.
+// The collector runs with its own service account, which is only allowed to federate the user workload prometheus.
+func updateUWLMetricsCollector(ctx context.Context, c client.Client, obsAddonSpec oashared.ObservabilityAddonSpec,
+	hubInfo HubInfo, config EndpointConfig, clusterID string, clusterType string, allowlist MetricsAllowlist,
+	keepAllowlist bool, forceRestart bool) error {
+	if !config.UserWorkloadMetrics.Enabled || (allowlist.UserWorkload.isEmpty() && !keepAllowlist) {
+		return deleteUWLMetricsCollector(ctx, c)
+	}
+
+	if err := createUWLCollectorRBAC(ctx, c); err != nil {
+		return err
+	}
+	uwlAllowlist := MetricsAllowlist{}
+	if allowlist.UserWorkload != nil {
+		uwlAllowlist = *allowlist.UserWorkload
+	}
+	hash, err := updateCollectorConfig(ctx, c, uwlCollector.configName, uwlAllowlist, keepAllowlist)
+	if err != nil {
+		return err
+	}
+	// the collector federates from the user workload metrics source with a single replica,
+	// its service account is not granted the leases for the leader election
+	uwlConfig := config
+	uwlConfig.MetricsSource = uwlMetricsSource(config)
+	uwlConfig.HighAvailability = CollectorHighAvailability{}
+	deployment := createShardDeployment(uwlCollector, clusterID, clusterType, obsAddonSpec, hubInfo, uwlConfig, hash, 1)
+	return applyCollectorDeployment(ctx, c, deployment, hash, forceRestart)
+}
+
+// uwlMetricsSource returns the metrics source of the user workload metrics collector
+func uwlMetricsSource(config EndpointConfig) MetricsSource {
+	source := config.UserWorkloadMetrics.MetricsSource
+	if source.ServerURL == "" {
+		source.ServerURL = defaultUWLPromURL
+	}
+	return source
+}
+
+// createUWLCollectorRBAC creates the service account of the user workload metrics collector,
+// and binds it to the clusterrole which only grants the api of the user workload prometheus
+func createUWLCollectorRBAC(ctx context.Context, c client.Client) error {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      uwlServiceAccountName,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+	}
+	err := c.Get(ctx, types.NamespacedName{Name: uwlServiceAccountName, Namespace: namespace}, &corev1.ServiceAccount{})
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to check the serviceaccount", "name", uwlServiceAccountName)
+		return err
+	}
+	if errors.IsNotFound(err) {
+		if err := c.Create(ctx, sa); err != nil {
+			log.Error(err, "Failed to create the serviceaccount", "name", uwlServiceAccountName)
+			return err
+		}
+		log.Info("serviceaccount created", "name", uwlServiceAccountName)
+	}
+
+	role := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: uwlClusterRoleName,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{"monitoring.coreos.com"},
+				Resources:     []string{"prometheuses/api"},
+				ResourceNames: []string{uwlPrometheusName},
+				Verbs:         []string{"get"},
+			},
+		},
+	}
+	found := &rbacv1.ClusterRole{}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlClusterRoleName}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to check the clusterrole", "name", uwlClusterRoleName)
+		return err
+	}
+	if errors.IsNotFound(err) {
+		err = c.Create(ctx, role)
+	} else if !reflect.DeepEqual(role.Rules, found.Rules) {
+		role.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+		err = c.Update(ctx, role)
+	}
+	if err != nil {
+		log.Error(err, "Failed to create or update the clusterrole", "name", uwlClusterRoleName)
+		return err
+	}
+
+	return createClusterRoleBinding(ctx, c, uwlClusterRoleBindingName, uwlClusterRoleName, uwlServiceAccountName)
+}
+
+// deleteUWLMetricsCollector deletes the collector for the user workload metrics and its RBAC
+func deleteUWLMetricsCollector(ctx context.Context, c client.Client) error {
+	if err := deleteCollector(ctx, c, uwlCollector); err != nil {
+		return err
+	}
+	if err := deleteClusterRoleBinding(ctx, c, uwlClusterRoleBindingName); err != nil {
+		return err
+	}
+	if err := deleteIfExists(ctx, c, types.NamespacedName{Name: uwlClusterRoleName}, &rbacv1.ClusterRole{}); err != nil {
+		return err
+	}
+	return deleteIfExists(ctx, c, types.NamespacedName{Name: uwlServiceAccountName, Namespace: namespace},
+		&corev1.ServiceAccount{})
+}
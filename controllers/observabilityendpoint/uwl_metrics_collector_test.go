@@ -0,0 +1,134 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
+)
+
+func TestUWLMetricsCollector(t *testing.T) {
+	hubInfo := HubInfo{
+		ClusterName: "test-cluster",
+		Endpoint:    "http://test-endpoint",
+	}
+	obsAddon := oashared.ObservabilityAddonSpec{
+		EnableMetrics: true,
+		Interval:      60,
+	}
+	allowlistCM := newAllowlistCM(customMetricsConfigMapName, nil, `
+userWorkload:
+  names:
+    - app_requests_total
+`)
+
+	ctx := context.TODO()
+	c := fake.NewFakeClient(getAllowlistCM(), allowlistCM)
+	list, err := getMetricsAllowlist(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to get metrics allowlist: (%v)", err)
+	}
+	config := EndpointConfig{
+		UserWorkloadMetrics: UserWorkloadMetrics{Enabled: true},
+		HighAvailability:    CollectorHighAvailability{Enabled: true},
+	}
+
+	err = updateUWLMetricsCollector(ctx, c, obsAddon, hubInfo, config, testClusterID, "", list, false, false)
+	if err != nil {
+		t.Fatalf("Failed to create uwl metrics collector: (%v)", err)
+	}
+	deploy := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlMetricsCollectorName, Namespace: namespace}, deploy)
+	if err != nil {
+		t.Fatalf("Failed to get uwl metrics collector deployment: (%v)", err)
+	}
+	// the high availability only applies to the platform collector
+	if *deploy.Spec.Replicas != 1 {
+		t.Fatalf("Wrong replicas in uwl metrics collector deployment: %d", *deploy.Spec.Replicas)
+	}
+	if deploy.Spec.Template.Labels[selectorKey] != uwlSelectorValue {
+		t.Fatalf("Wrong component label in uwl metrics collector deployment: %v", deploy.Spec.Template.Labels)
+	}
+	from := ""
+	for _, env := range deploy.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "FROM" {
+			from = env.Value
+		}
+	}
+	if from != defaultUWLPromURL {
+		t.Fatalf("Wrong FROM in uwl metrics collector deployment: %s", from)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlCollectorConfigName, Namespace: namespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get uwl metrics collector config: (%v)", err)
+	}
+	if !strings.Contains(cm.Data[collectorConfigKey], "app_requests_total") {
+		t.Fatalf("Wrong uwl metrics collector config: (%v)", cm.Data[collectorConfigKey])
+	}
+	if strings.Contains(cm.Data[collectorConfigKey], "{__name__=\"a\"}") {
+		t.Fatalf("Platform metrics in uwl metrics collector config: (%v)", cm.Data[collectorConfigKey])
+	}
+	// the collector federates with its own service account, which is only granted the user workload prometheus
+	if deploy.Spec.Template.Spec.ServiceAccountName != uwlServiceAccountName {
+		t.Fatalf("Wrong service account in uwl metrics collector deployment: %s",
+			deploy.Spec.Template.Spec.ServiceAccountName)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlServiceAccountName, Namespace: namespace}, &corev1.ServiceAccount{})
+	if err != nil {
+		t.Fatalf("Failed to get uwl metrics collector serviceaccount: (%v)", err)
+	}
+	role := &rbacv1.ClusterRole{}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlClusterRoleName}, role)
+	if err != nil {
+		t.Fatalf("Failed to get uwl metrics collector clusterrole: (%v)", err)
+	}
+	if len(role.Rules) != 1 || !reflect.DeepEqual(role.Rules[0].ResourceNames, []string{uwlPrometheusName}) {
+		t.Fatalf("Wrong rules in uwl metrics collector clusterrole: %v", role.Rules)
+	}
+	rb := &rbacv1.ClusterRoleBinding{}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlClusterRoleBindingName}, rb)
+	if err != nil {
+		t.Fatalf("Failed to get uwl metrics collector clusterrolebinding: (%v)", err)
+	}
+	if rb.RoleRef.Name != uwlClusterRoleName || len(rb.Subjects) != 1 || rb.Subjects[0].Name != uwlServiceAccountName {
+		t.Fatalf("Wrong uwl metrics collector clusterrolebinding: %v", rb)
+	}
+
+	// Disabling the user workload metrics removes the collector
+	err = updateUWLMetricsCollector(ctx, c, obsAddon, hubInfo, EndpointConfig{}, testClusterID, "", list, false, false)
+	if err != nil {
+		t.Fatalf("Failed to delete uwl metrics collector: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlMetricsCollectorName, Namespace: namespace}, &appsv1.Deployment{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("uwl metrics collector deployment not deleted")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlCollectorConfigName, Namespace: namespace}, &corev1.ConfigMap{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("uwl metrics collector config not deleted")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlClusterRoleBindingName}, &rbacv1.ClusterRoleBinding{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("uwl metrics collector clusterrolebinding not deleted")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlClusterRoleName}, &rbacv1.ClusterRole{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("uwl metrics collector clusterrole not deleted")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: uwlServiceAccountName, Namespace: namespace}, &corev1.ServiceAccount{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("uwl metrics collector serviceaccount not deleted")
+	}
+}
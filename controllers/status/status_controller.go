@@ -6,7 +6,9 @@ import (
 	"context"
 	"os"
 	"reflect"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -15,6 +17,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
+	"github.com/stolostron/endpoint-metrics-operator/pkg/util"
 	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
 )
 
@@ -36,6 +39,8 @@ type StatusReconciler struct {
 	Client    client.Client
 	Scheme    *runtime.Scheme
 	HubClient client.Client
+	// HubBackoff is the retry policy to push the status when the hub cluster is unreachable
+	HubBackoff *util.Backoff
 }
 
 // Reconcile reads that state of the cluster for a ObservabilityAddon object and makes changes based on the state read
@@ -46,12 +51,24 @@ func (r *StatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	log := log.WithValues("Request.Namespace", req.Namespace, "Request.Name", req.Name)
 	log.Info("Reconciling")
 
+	if r.HubBackoff == nil {
+		r.HubBackoff = &util.Backoff{}
+	}
+
 	// Fetch the ObservabilityAddon instance in hub cluster
 	hubObsAddon := &oav1beta1.ObservabilityAddon{}
 	err := r.HubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: hubNamespace}, hubObsAddon)
+	util.RecordHubReachable(err == nil || errors.IsNotFound(err))
 	if err != nil {
-		log.Error(err, "Failed to get observabilityaddon in hub cluster", "namespace", hubNamespace)
-		return ctrl.Result{}, err
+		if errors.IsNotFound(err) {
+			log.Error(err, "Failed to get observabilityaddon in hub cluster", "namespace", hubNamespace)
+			return ctrl.Result{}, err
+		}
+		// the status is kept in the local observabilityaddon, and pushed once the hub cluster is reachable
+		retryAfter := r.HubBackoff.Next()
+		log.Error(err, "Hub cluster is unreachable, the status will be pushed later",
+			"namespace", hubNamespace, "retryAfter", retryAfter)
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
 	}
 
 	// Fetch the ObservabilityAddon instance in local cluster
@@ -62,12 +79,21 @@ func (r *StatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, err
 	}
 
+	if reflect.DeepEqual(hubObsAddon.Status, obsAddon.Status) {
+		r.HubBackoff.Reset()
+		util.RecordStatusSync(time.Now())
+		return ctrl.Result{}, nil
+	}
 	hubObsAddon.Status = obsAddon.Status
 
 	err = r.HubClient.Status().Update(ctx, hubObsAddon)
 	if err != nil {
 		log.Error(err, "Failed to update status for observabilityaddon in hub cluster", "namespace", hubNamespace)
+		retryAfter := r.HubBackoff.Next()
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
 	}
+	r.HubBackoff.Reset()
+	util.RecordStatusSync(time.Now())
 
 	return ctrl.Result{}, nil
 }
@@ -79,8 +105,9 @@ func (r *StatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}
 
 	pred := predicate.Funcs{
+		// push the status on startup, in case it was not pushed before the restart
 		CreateFunc: func(e event.CreateEvent) bool {
-			return false
+			return e.Object.GetNamespace() == namespace
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			if e.ObjectNew.GetNamespace() == namespace &&
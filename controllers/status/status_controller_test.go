@@ -4,16 +4,20 @@ package status
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	addonv1alpha1 "github.com/open-cluster-management/api/addon/v1alpha1"
+	"github.com/stolostron/endpoint-metrics-operator/pkg/util"
 	oashared "github.com/stolostron/multicluster-observability-operator/api/shared"
 	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
 )
@@ -37,6 +41,15 @@ func newObservabilityAddon(name string, ns string) *oav1beta1.ObservabilityAddon
 	}
 }
 
+// unreachableClient simulates the hub cluster which cannot be connected
+type unreachableClient struct {
+	client.Client
+}
+
+func (c unreachableClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return fmt.Errorf("dial tcp: connection refused")
+}
+
 func init() {
 	s := scheme.Scheme
 	addonv1alpha1.AddToScheme(s)
@@ -112,3 +125,63 @@ func TestStatusController(t *testing.T) {
 		t.Fatalf("Wrong status type: (%v)", hubObsAddon.Status)
 	}
 }
+
+func TestStatusControllerHubUnreachable(t *testing.T) {
+	hubClient := fake.NewFakeClient(newObservabilityAddon(name, testHubNamspace))
+	oba := newObservabilityAddon(name, testNamespace)
+	oba.Status = oav1beta1.ObservabilityAddonStatus{
+		Conditions: []oav1beta1.StatusCondition{
+			{
+				Type:    "Available",
+				Status:  metav1.ConditionTrue,
+				Reason:  "Deployed",
+				Message: "Metrics collector deployed",
+			},
+		},
+	}
+	c := fake.NewFakeClient(oba)
+	r := &StatusReconciler{
+		Client:     c,
+		HubClient:  unreachableClient{hubClient},
+		HubBackoff: &util.Backoff{MinDelay: time.Second, MaxDelay: 4 * time.Second},
+	}
+	ctx := context.TODO()
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+	}
+
+	// test the status push is retried with backoff while the hub is unreachable
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second} {
+		result, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatalf("Failed to reconcile: (%v)", err)
+		}
+		if result.RequeueAfter != expected {
+			t.Fatalf("Wrong retry delay, expected: %v, got: %v", expected, result.RequeueAfter)
+		}
+	}
+
+	// test the buffered status is pushed once the hub is reachable
+	r.HubClient = hubClient
+	result, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: (%v)", err)
+	}
+	if result.RequeueAfter != 0 {
+		t.Fatalf("Unexpected requeue after the status pushed: (%v)", result.RequeueAfter)
+	}
+	hubObsAddon := &oav1beta1.ObservabilityAddon{}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testHubNamspace}, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to get oba in hub: (%v)", err)
+	}
+	if len(hubObsAddon.Status.Conditions) != 1 || hubObsAddon.Status.Conditions[0].Type != "Available" {
+		t.Fatalf("Buffered status not pushed to hub: (%v)", hubObsAddon.Status)
+	}
+	if r.HubBackoff.Failures() != 0 {
+		t.Fatalf("Backoff not reset after the status pushed")
+	}
+}
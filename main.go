@@ -8,13 +8,17 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	"github.com/IBM/controller-filtered-cache/filteredcache"
 	ocinfrav1 "github.com/openshift/api/config/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -32,6 +36,11 @@ import (
 	// +kubebuilder:scaffold:imports
 )
 
+const (
+	// the lease updater runs every 60s with jitter
+	leaseStallThreshold = 3 * time.Minute
+)
+
 var (
 	scheme   = k8sruntime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -55,11 +64,26 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var resyncPeriod time.Duration
+	var hubRetryMinDelay time.Duration
+	var hubRetryMaxDelay time.Duration
+	var hubUnreachableThreshold time.Duration
+	var maxReconcileFailures int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8383", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"The interval to reconcile the observabilityaddon periodically. Set it to 0 to disable the periodic resync.")
+	flag.DurationVar(&hubRetryMinDelay, "hub-retry-min-delay", 5*time.Second,
+		"The initial delay to retry when the hub cluster is unreachable.")
+	flag.DurationVar(&hubRetryMaxDelay, "hub-retry-max-delay", 5*time.Minute,
+		"The maximum delay to retry when the hub cluster is unreachable.")
+	flag.DurationVar(&hubUnreachableThreshold, "hub-unreachable-threshold", 5*time.Minute,
+		"The duration the hub cluster can be unreachable before the operator is not ready.")
+	flag.IntVar(&maxReconcileFailures, "max-reconcile-failures", 5,
+		"The number of the consecutive reconcile failures before the operator is not ready. Set it to 0 to disable the check.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -76,12 +100,27 @@ func main() {
 		v1.SchemeGroupVersion.WithKind("ConfigMap"): {
 			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
 		},
+		v1.SchemeGroupVersion.WithKind("Pod"): {
+			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
+		},
 		appsv1.SchemeGroupVersion.WithKind("Deployment"): {
 			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
 		},
 		oav1beta1.GroupVersion.WithKind("ObservabilityAddon"): {
 			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
 		},
+		policyv1.SchemeGroupVersion.WithKind("PodDisruptionBudget"): {
+			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
+		},
+		rbacv1.SchemeGroupVersion.WithKind("Role"): {
+			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
+		},
+		rbacv1.SchemeGroupVersion.WithKind("RoleBinding"): {
+			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
+		},
+		coordinationv1.SchemeGroupVersion.WithKind("Lease"): {
+			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
+		},
 	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
@@ -98,24 +137,42 @@ func main() {
 		os.Exit(1)
 	}
 
-	hubClient, err := util.GetOrCreateHubClient()
+	hubProvider, err := util.NewHubClientProvider(util.HubKubeConfigPath, mgr.GetScheme())
 	if err != nil {
 		setupLog.Error(err, "Failed to create the hub client")
 		os.Exit(1)
 	}
+	if err := mgr.Add(hubProvider); err != nil {
+		setupLog.Error(err, "unable to add the hub client provider")
+		os.Exit(1)
+	}
+	hubClient := hubProvider.Client()
+
+	appAllowlistCache, err := obsepctl.NewAppAllowlistCache(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create the cache of the application allowlists")
+		os.Exit(1)
+	}
 
 	if err = (&obsepctl.ObservabilityAddonReconciler{
-		Client:    mgr.GetClient(),
-		Scheme:    mgr.GetScheme(),
-		HubClient: hubClient,
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		HubClient:    hubClient,
+		ResyncPeriod: resyncPeriod,
+		HubBackoff:   &util.Backoff{MinDelay: hubRetryMinDelay, MaxDelay: hubRetryMaxDelay},
+		HubProvider:  hubProvider,
+		Recorder:     mgr.GetEventRecorderFor("endpoint-metrics-operator"),
+
+		AppAllowlistCache: appAllowlistCache,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ObservabilityAddon")
 		os.Exit(1)
 	}
 	if err = (&statusctl.StatusReconciler{
-		Client:    mgr.GetClient(),
-		Scheme:    mgr.GetScheme(),
-		HubClient: hubClient,
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		HubClient:  hubClient,
+		HubBackoff: &util.Backoff{MinDelay: hubRetryMinDelay, MaxDelay: hubRetryMaxDelay},
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Status")
 		os.Exit(1)
@@ -126,9 +183,25 @@ func main() {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
+	if err := mgr.AddHealthzCheck("lease", util.LeaseLivenessCheck(leaseStallThreshold)); err != nil {
+		setupLog.Error(err, "unable to set up lease health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("ready", util.ReadyzCheck(hubUnreachableThreshold, maxReconcileFailures)); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
 
-	// start lease
-	util.StartLease()
+	// the lease updater uses the hub client following the rotated hub kubeconfig
+	leaseUpdater, err := util.NewLeaseUpdater(hubClient)
+	if err != nil {
+		setupLog.Error(err, "unable to create the lease updater")
+		os.Exit(1)
+	}
+	if err := mgr.Add(leaseUpdater); err != nil {
+		setupLog.Error(err, "unable to add the lease updater")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackoffMinDelay = 5 * time.Second
+	defaultBackoffMaxDelay = 5 * time.Minute
+)
+
+// Backoff is the bounded exponential backoff used to retry when the hub cluster is unreachable
+type Backoff struct {
+	// MinDelay is the delay of the first retry, it is 5s if not set
+	MinDelay time.Duration
+	// MaxDelay is the upper bound of the delay, it is 5m if not set
+	MaxDelay time.Duration
+
+	mu       sync.Mutex
+	failures int
+}
+
+// Next records a failure and returns the delay before the next retry
+func (b *Backoff) Next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	min, max := b.MinDelay, b.MaxDelay
+	if min <= 0 {
+		min = defaultBackoffMinDelay
+	}
+	if max < min {
+		max = defaultBackoffMaxDelay
+		if max < min {
+			max = min
+		}
+	}
+	delay := min
+	for i := 0; i < b.failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	b.failures++
+	return delay
+}
+
+// Reset clears the failures once the hub cluster is reachable again
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// Failures returns the number of the consecutive failures
+func (b *Backoff) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
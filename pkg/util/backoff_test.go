@@ -0,0 +1,31 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &Backoff{MinDelay: time.Second, MaxDelay: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		if d := b.Next(); d != e {
+			t.Fatalf("Wrong delay for retry %d, expected: %v, got: %v", i, e, d)
+		}
+	}
+	if b.Failures() != len(expected) {
+		t.Fatalf("Wrong failures, expected: %d, got: %d", len(expected), b.Failures())
+	}
+
+	b.Reset()
+	if d := b.Next(); d != time.Second {
+		t.Fatalf("Delay not reset, got: %v", d)
+	}
+
+	b = &Backoff{}
+	if d := b.Next(); d != defaultBackoffMinDelay {
+		t.Fatalf("Wrong default delay, got: %v", d)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// the state tracked for the health probes
+var (
+	healthMu            sync.Mutex
+	hubUnreachableSince time.Time
+	hubConfigErr        error
+	reconcileFailures   int
+	leaseStarted        time.Time
+	leaseHeartbeat      time.Time
+)
+
+func trackHubReachable(reachable bool, now time.Time) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if reachable {
+		hubUnreachableSince = time.Time{}
+	} else if hubUnreachableSince.IsZero() {
+		hubUnreachableSince = now
+	}
+}
+
+func trackHubConfig(err error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	hubConfigErr = err
+}
+
+// RecordReconcileResult records the result of the observabilityaddon reconcile
+func RecordReconcileResult(err error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if err != nil {
+		reconcileFailures++
+	} else {
+		reconcileFailures = 0
+	}
+}
+
+// recordLeaseHeartbeat is called by the lease updater in each loop
+func recordLeaseHeartbeat(now time.Time) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	leaseHeartbeat = now
+}
+
+// ReadyzCheck fails if the hub kubeconfig cannot be loaded, the hub cluster has been unreachable
+// longer than hubUnreachableThreshold, or the last maxReconcileFailures reconciles all failed
+func ReadyzCheck(hubUnreachableThreshold time.Duration, maxReconcileFailures int) healthz.Checker {
+	return func(_ *http.Request) error {
+		healthMu.Lock()
+		defer healthMu.Unlock()
+		if hubConfigErr != nil {
+			return fmt.Errorf("failed to load the hub kubeconfig: %v", hubConfigErr)
+		}
+		if !hubUnreachableSince.IsZero() && time.Since(hubUnreachableSince) > hubUnreachableThreshold {
+			return fmt.Errorf("hub cluster has been unreachable since %s", hubUnreachableSince.Format(time.RFC3339))
+		}
+		if maxReconcileFailures > 0 && reconcileFailures >= maxReconcileFailures {
+			return fmt.Errorf("the last %d reconciles failed", reconcileFailures)
+		}
+		return nil
+	}
+}
+
+// LeaseLivenessCheck fails if the lease updater has not run longer than the threshold after it is started
+func LeaseLivenessCheck(threshold time.Duration) healthz.Checker {
+	return func(_ *http.Request) error {
+		healthMu.Lock()
+		defer healthMu.Unlock()
+		if leaseStarted.IsZero() {
+			return nil
+		}
+		last := leaseHeartbeat
+		if last.IsZero() {
+			last = leaseStarted
+		}
+		if time.Since(last) > threshold {
+			return fmt.Errorf("lease updater has not run since %s", last.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func resetHealth() {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	hubUnreachableSince = time.Time{}
+	hubConfigErr = nil
+	reconcileFailures = 0
+	leaseStarted = time.Time{}
+	leaseHeartbeat = time.Time{}
+}
+
+func TestReadyzCheck(t *testing.T) {
+	resetHealth()
+	defer resetHealth()
+	check := ReadyzCheck(time.Minute, 3)
+
+	if err := check(nil); err != nil {
+		t.Fatalf("Unexpected failure for the initial state: (%v)", err)
+	}
+
+	// test the hub unreachable threshold
+	trackHubReachable(false, time.Now().Add(-30*time.Second))
+	if err := check(nil); err != nil {
+		t.Fatalf("Unexpected failure before the threshold: (%v)", err)
+	}
+	trackHubReachable(true, time.Now())
+	trackHubReachable(false, time.Now().Add(-2*time.Minute))
+	if err := check(nil); err == nil {
+		t.Fatal("Missed the failure for the unreachable hub")
+	}
+	trackHubReachable(true, time.Now())
+
+	// test the hub kubeconfig error
+	trackHubConfig(fmt.Errorf("invalid kubeconfig"))
+	if err := check(nil); err == nil {
+		t.Fatal("Missed the failure for the invalid hub kubeconfig")
+	}
+	trackHubConfig(nil)
+
+	// test the consecutive reconcile failures
+	for i := 0; i < 2; i++ {
+		RecordReconcileResult(fmt.Errorf("failed"))
+	}
+	if err := check(nil); err != nil {
+		t.Fatalf("Unexpected failure before the max reconcile failures: (%v)", err)
+	}
+	RecordReconcileResult(fmt.Errorf("failed"))
+	if err := check(nil); err == nil {
+		t.Fatal("Missed the failure for the reconcile failures")
+	}
+	RecordReconcileResult(nil)
+	if err := check(nil); err != nil {
+		t.Fatalf("Failure not cleared after the successful reconcile: (%v)", err)
+	}
+}
+
+func TestLeaseLivenessCheck(t *testing.T) {
+	resetHealth()
+	defer resetHealth()
+	check := LeaseLivenessCheck(time.Minute)
+
+	if err := check(nil); err != nil {
+		t.Fatalf("Unexpected failure before the lease is started: (%v)", err)
+	}
+	healthMu.Lock()
+	leaseStarted = time.Now().Add(-2 * time.Minute)
+	healthMu.Unlock()
+	if err := check(nil); err == nil {
+		t.Fatal("Missed the failure for the lease updater never run")
+	}
+	recordLeaseHeartbeat(time.Now())
+	if err := check(nil); err != nil {
+		t.Fatalf("Unexpected failure after the heartbeat: (%v)", err)
+	}
+	recordLeaseHeartbeat(time.Now().Add(-2 * time.Minute))
+	if err := check(nil); err == nil {
+		t.Fatal("Missed the failure for the stuck lease updater")
+	}
+}
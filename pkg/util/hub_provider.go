@@ -0,0 +1,224 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	hubConfigCheckInterval = 30 * time.Second
+)
+
+// HubClientProvider builds the config and client for the hub cluster from the hub kubeconfig,
+// and rebuilds them when the kubeconfig or the cert files referenced by it are rotated
+type HubClientProvider struct {
+	kubeconfigPath string
+	scheme         *runtime.Scheme
+	checkInterval  time.Duration
+	// newClient builds the client from the config, it is replaced in the tests
+	newClient func(*rest.Config, client.Options) (client.Client, error)
+
+	mu          sync.RWMutex
+	hash        string
+	generation  int
+	config      *rest.Config
+	client      client.Client
+	subscribers []chan struct{}
+}
+
+// NewHubClientProvider creates the provider and builds the hub client from the kubeconfig
+func NewHubClientProvider(kubeconfigPath string, scheme *runtime.Scheme) (*HubClientProvider, error) {
+	p := &HubClientProvider{
+		kubeconfigPath: kubeconfigPath,
+		scheme:         scheme,
+		checkInterval:  hubConfigCheckInterval,
+		newClient:      client.New,
+	}
+	if _, err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Config returns a copy of the current hub config
+func (p *HubClientProvider) Config() *rest.Config {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return rest.CopyConfig(p.config)
+}
+
+// Client returns the hub client which always delegates to the latest built client,
+// so that the holders of the client don't need to be aware of the rotation
+func (p *HubClientProvider) Client() client.Client {
+	return &reloadingClient{p: p}
+}
+
+// Generation returns the number of the times the hub config is built
+func (p *HubClientProvider) Generation() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.generation
+}
+
+// Subscribe returns the channel which is notified when the hub config is rebuilt
+func (p *HubClientProvider) Subscribe() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	p.subscribers = append(p.subscribers, ch)
+	return ch
+}
+
+// Start checks the hub kubeconfig periodically until the context is done, it implements manager.Runnable
+func (p *HubClientProvider) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := p.reload(); err != nil {
+				log.Error(err, "Failed to reload the hub kubeconfig, keep using the current one")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns false since the hub client is used by all the replicas
+func (p *HubClientProvider) NeedLeaderElection() bool {
+	return false
+}
+
+// reload rebuilds the hub config and client if the kubeconfig or the referenced files are changed.
+// It returns true if they are rebuilt.
+func (p *HubClientProvider) reload() (bool, error) {
+	changed, err := p.doReload()
+	trackHubConfig(err)
+	return changed, err
+}
+
+func (p *HubClientProvider) doReload() (bool, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", p.kubeconfigPath)
+	if err != nil {
+		log.Error(err, "Failed to create the hub config")
+		return false, err
+	}
+	hash, err := hubConfigHash(p.kubeconfigPath, config)
+	if err != nil {
+		log.Error(err, "Failed to read the hub kubeconfig")
+		return false, err
+	}
+
+	p.mu.RLock()
+	changed := hash != p.hash
+	p.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	c, err := p.newClient(config, client.Options{Scheme: p.scheme})
+	if err != nil {
+		log.Error(err, "Failed to create hub client")
+		return false, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	first := p.client == nil
+	p.hash = hash
+	p.generation++
+	p.config = config
+	p.client = c
+	if !first {
+		log.Info("Hub kubeconfig is rotated, the hub client is rebuilt")
+		for _, ch := range p.subscribers {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return true, nil
+}
+
+func (p *HubClientProvider) current() client.Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.client
+}
+
+// hubConfigHash returns the hash of the kubeconfig and the cert files referenced by it
+func hubConfigHash(kubeconfigPath string, config *rest.Config) (string, error) {
+	h := sha256.New()
+	for _, path := range []string{kubeconfigPath, config.TLSClientConfig.CertFile,
+		config.TLSClientConfig.KeyFile, config.TLSClientConfig.CAFile} {
+		if path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// reloadingClient is the client.Client delegating to the latest hub client of the provider
+type reloadingClient struct {
+	p *HubClientProvider
+}
+
+func (c *reloadingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return c.p.current().Get(ctx, key, obj)
+}
+
+func (c *reloadingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.p.current().List(ctx, list, opts...)
+}
+
+func (c *reloadingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.p.current().Create(ctx, obj, opts...)
+}
+
+func (c *reloadingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.p.current().Delete(ctx, obj, opts...)
+}
+
+func (c *reloadingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.p.current().Update(ctx, obj, opts...)
+}
+
+func (c *reloadingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch,
+	opts ...client.PatchOption) error {
+	return c.p.current().Patch(ctx, obj, patch, opts...)
+}
+
+func (c *reloadingClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	return c.p.current().DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *reloadingClient) Status() client.StatusWriter {
+	return c.p.current().Status()
+}
+
+func (c *reloadingClient) Scheme() *runtime.Scheme {
+	return c.p.current().Scheme()
+}
+
+func (c *reloadingClient) RESTMapper() meta.RESTMapper {
+	return c.p.current().RESTMapper()
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testKubeconfig = `
+apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://hub.example.com:6443
+    insecure-skip-tls-verify: true
+  name: hub
+contexts:
+- context:
+    cluster: hub
+    user: agent
+  name: hub
+current-context: hub
+users:
+- name: agent
+  user:
+    client-certificate: %s
+    client-key: %s
+`
+
+func TestHubClientProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hub-kubeconfig")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: (%v)", err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfigPath := filepath.Join(dir, "kubeconfig")
+	certPath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+	writeFile := func(path, data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to write file %s: (%v)", path, err)
+		}
+	}
+	writeFile(kubeconfigPath, fmt.Sprintf(testKubeconfig, certPath, keyPath))
+	writeFile(certPath, "cert-1")
+	writeFile(keyPath, "key-1")
+
+	// every built client has a configmap named after the build count
+	builds := 0
+	p := &HubClientProvider{
+		kubeconfigPath: kubeconfigPath,
+		scheme:         scheme.Scheme,
+		newClient: func(config *rest.Config, opts client.Options) (client.Client, error) {
+			builds++
+			return fake.NewFakeClient(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("build-%d", builds), Namespace: "test"},
+			}), nil
+		},
+	}
+	reloaded := p.Subscribe()
+	c := p.Client()
+	ctx := context.TODO()
+
+	if changed, err := p.reload(); err != nil || !changed {
+		t.Fatalf("Failed to load the hub kubeconfig: (%v, %v)", changed, err)
+	}
+	if len(reloaded) != 0 {
+		t.Fatal("Subscribers should not be notified for the initial load")
+	}
+	if p.Config().Host != "https://hub.example.com:6443" {
+		t.Fatalf("Wrong hub server: (%s)", p.Config().Host)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "build-1", Namespace: "test"}, &corev1.ConfigMap{}); err != nil {
+		t.Fatalf("Client not delegated to the built client: (%v)", err)
+	}
+
+	if changed, err := p.reload(); err != nil || changed {
+		t.Fatalf("Client rebuilt for the unchanged kubeconfig: (%v, %v)", changed, err)
+	}
+
+	// test the client is rebuilt when the referenced cert is rotated
+	writeFile(certPath, "cert-2")
+	if changed, err := p.reload(); err != nil || !changed {
+		t.Fatalf("Client not rebuilt for the rotated cert: (%v, %v)", changed, err)
+	}
+	if len(reloaded) != 1 {
+		t.Fatal("Subscriber not notified for the rotated cert")
+	}
+	if p.Generation() != 2 {
+		t.Fatalf("Wrong generation, expected: 2, got: %d", p.Generation())
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "build-2", Namespace: "test"}, &corev1.ConfigMap{}); err != nil {
+		t.Fatalf("Client not delegated to the rebuilt client: (%v)", err)
+	}
+
+	// test the current client is kept if the kubeconfig is broken
+	writeFile(kubeconfigPath, "invalid")
+	if _, err := p.reload(); err == nil {
+		t.Fatal("Missed the error for the invalid kubeconfig")
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "build-2", Namespace: "test"}, &corev1.ConfigMap{}); err != nil {
+		t.Fatalf("Current client not kept: (%v)", err)
+	}
+}
@@ -6,16 +6,25 @@ package util
 import (
 	"context"
 	"os"
+	"time"
 
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/open-cluster-management/addon-framework/pkg/lease"
 )
 
 const (
 	leaseName = "observability-controller"
+	// the same duration and jitter as the lease updater of the addon framework
+	leaseDurationSeconds    = 60
+	leaseUpdateJitterFactor = 0.25
 )
 
 var (
@@ -23,28 +32,114 @@ var (
 	clusterName = os.Getenv("HUB_NAMESPACE")
 )
 
-func StartLease() {
+// LeaseUpdater renews the addon lease in the managed cluster, or in the cluster namespace of the hub cluster
+// if the lease is not available in the managed cluster. It is built once and always uses the current hub client,
+// so the rotation of the hub kubeconfig needs no new updater.
+type LeaseUpdater struct {
+	kubeClient kubernetes.Interface
+	hubClient  client.Client
+	// healthChecks must all pass for the lease to be renewed
+	healthChecks []func() bool
+}
+
+// NewLeaseUpdater creates the lease updater with the in-cluster config,
+// the hub client is expected to follow the rotated hub kubeconfig, e.g. the one from HubClientProvider
+func NewLeaseUpdater(hubClient client.Client) (*LeaseUpdater, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		log.Error(err, "Failed to create incluster config")
-		panic(err.Error())
+		return nil, err
 	}
-	// creates the clientset
 	c, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Error(err, "Failed to create kube client")
-		panic(err.Error())
+		return nil, err
+	}
+	return newLeaseUpdater(c, hubClient,
+		lease.CheckAddonPodFunc(c.CoreV1(), namespace, "name=endpoint-observability-operator")), nil
+}
+
+func newLeaseUpdater(kubeClient kubernetes.Interface, hubClient client.Client,
+	healthChecks ...func() bool) *LeaseUpdater {
+	return &LeaseUpdater{
+		kubeClient:   kubeClient,
+		hubClient:    hubClient,
+		healthChecks: healthChecks,
 	}
+}
 
-	// create the config from the path
-	hubConfig, err := clientcmd.BuildConfigFromFlags("", hubKubeConfigPath)
+// Start renews the lease periodically until the context is done
+func (u *LeaseUpdater) Start(ctx context.Context) error {
+	healthMu.Lock()
+	leaseStarted = time.Now()
+	healthMu.Unlock()
+	wait.JitterUntilWithContext(ctx, u.reconcile, leaseDurationSeconds*time.Second, leaseUpdateJitterFactor, true)
+	return nil
+}
+
+// NeedLeaderElection implements LeaderElectionRunnable, the lease is renewed by every replica
+func (u *LeaseUpdater) NeedLeaderElection() bool {
+	return false
+}
+
+func (u *LeaseUpdater) reconcile(ctx context.Context) {
+	recordLeaseHeartbeat(time.Now())
+	for _, check := range u.healthChecks {
+		if !check() {
+			return
+		}
+	}
+	err := u.updateLocalLease(ctx)
+	if err == nil {
+		return
+	}
+	log.Error(err, "Failed to update the lease in the managed cluster", "name", leaseName, "namespace", namespace)
+	// the lease is not supported in the managed cluster, fall back to the hub lease
+	if errors.IsNotFound(err) && u.hubClient != nil {
+		if err := u.updateHubLease(ctx); err != nil {
+			log.Error(err, "Failed to update the lease in the hub cluster", "name", leaseName, "namespace", clusterName)
+		}
+	}
+}
+
+func (u *LeaseUpdater) updateLocalLease(ctx context.Context) error {
+	leases := u.kubeClient.CoordinationV1().Leases(namespace)
+	found, err := leases.Get(ctx, leaseName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err = leases.Create(ctx, newLease(namespace), metav1.CreateOptions{})
+		return err
+	}
+	found.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+	_, err = leases.Update(ctx, found, metav1.UpdateOptions{})
+	return err
+}
+
+func (u *LeaseUpdater) updateHubLease(ctx context.Context) error {
+	found := &coordinationv1.Lease{}
+	err := u.hubClient.Get(ctx, types.NamespacedName{Name: leaseName, Namespace: clusterName}, found)
 	if err != nil {
-		log.Error(err, "Failed to create the hub config")
-		panic(err.Error())
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return u.hubClient.Create(ctx, newLease(clusterName))
 	}
+	found.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+	return u.hubClient.Update(ctx, found)
+}
 
-	actual := lease.CheckAddonPodFunc(c.CoreV1(), namespace, "name=endpoint-observability-operator")
-	leaseController := lease.NewLeaseUpdater(c, leaseName, namespace, actual).
-		WithHubLeaseConfig(hubConfig, clusterName)
-	go leaseController.Start(context.TODO())
+func newLease(ns string) *coordinationv1.Lease {
+	duration := int32(leaseDurationSeconds)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: ns,
+		},
+		Spec: coordinationv1.LeaseSpec{
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+		},
+	}
 }
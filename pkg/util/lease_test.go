@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLeaseUpdater(t *testing.T) {
+	ctx := context.TODO()
+	kubeClient := kubefake.NewSimpleClientset()
+	hubClient := fake.NewFakeClient()
+	healthy := false
+	u := newLeaseUpdater(kubeClient, hubClient, func() bool { return healthy })
+
+	// the lease is not renewed if the health check fails
+	u.reconcile(ctx)
+	_, err := kubeClient.CoordinationV1().Leases(namespace).Get(ctx, leaseName, metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Lease created with the failed health check: (%v)", err)
+	}
+
+	healthy = true
+	u.reconcile(ctx)
+	found, err := kubeClient.CoordinationV1().Leases(namespace).Get(ctx, leaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the lease in the managed cluster: (%v)", err)
+	}
+	renewed := found.Spec.RenewTime.Time
+	time.Sleep(time.Millisecond)
+	u.reconcile(ctx)
+	found, err = kubeClient.CoordinationV1().Leases(namespace).Get(ctx, leaseName, metav1.GetOptions{})
+	if err != nil || !found.Spec.RenewTime.Time.After(renewed) {
+		t.Fatalf("Lease not renewed in the managed cluster: (%v), (%v)", found, err)
+	}
+	if err := hubClient.Get(ctx, types.NamespacedName{Name: leaseName, Namespace: clusterName},
+		&coordinationv1.Lease{}); !errors.IsNotFound(err) {
+		t.Fatalf("Hub lease created while the lease is supported in the managed cluster: (%v)", err)
+	}
+
+	// fall back to the hub lease, the client set on the updater is used without rebuilding it
+	kubeClient.PrependReactor("*", "leases", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewNotFound(coordinationv1.Resource("leases"), leaseName)
+	})
+	for i := 0; i < 2; i++ {
+		u.reconcile(ctx)
+		if err := hubClient.Get(ctx, types.NamespacedName{Name: leaseName, Namespace: clusterName},
+			&coordinationv1.Lease{}); err != nil {
+			t.Fatalf("Failed to get the lease in the hub cluster: (%v)", err)
+		}
+	}
+}
+
+func TestLeaseUpdaterStop(t *testing.T) {
+	u := newLeaseUpdater(kubefake.NewSimpleClientset(), fake.NewFakeClient())
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan struct{})
+	go func() {
+		_ = u.Start(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lease updater not stopped with the context")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
+)
+
+const (
+	metricsNamespace = "endpoint_metrics_operator"
+)
+
+// the reconcile steps recorded in the metrics
+const (
+	StepHubFetch                = "hub_fetch"
+	StepFinalizer               = "finalizer"
+	StepClusterRoleBinding      = "cluster_role_binding"
+	StepCAConfigMap             = "ca_configmap"
+	StepClusterMonitoringConfig = "cluster_monitoring_config"
+	StepMetricsCollector        = "metrics_collector"
+	StepUWLMetricsCollector     = "uwl_metrics_collector"
+)
+
+var (
+	hubReachable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "hub_reachable",
+		Help:      "Whether the hub cluster was reachable in the last attempt, 1 for reachable and 0 for unreachable.",
+	})
+	reconcileSteps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_step_total",
+		Help:      "The number of the reconcile steps by the step and the result, which is success or failure.",
+	}, []string{"step", "result"})
+	allowlistSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "metrics_allowlist_size",
+		Help:      "The number of the entries in the merged metrics allowlist by the type.",
+	}, []string{"type"})
+	collectorConfigEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "metrics_collector_config_entries",
+		Help:      "The number of the entries rendered into the metrics collector config by the config and the type.",
+	}, []string{"config", "type"})
+	allowlistSeries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "allowlist_series",
+		Help:      "The number of the series of the allowlist entries counted in the metrics source.",
+	})
+	allowlistDroppedEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "allowlist_dropped_entries",
+		Help:      "The number of the allowlist entries dropped for the cardinality budget.",
+	})
+	statusLastSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "status_last_sync_timestamp_seconds",
+		Help:      "The timestamp of the last successful status sync to the hub cluster.",
+	})
+	addonCondition = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "addon_condition",
+		Help:      "The status of the conditions of the local observabilityaddon, 1 for True and 0 otherwise.",
+	}, []string{"type"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(hubReachable, reconcileSteps, allowlistSize, collectorConfigEntries,
+		allowlistSeries, allowlistDroppedEntries, statusLastSync, addonCondition)
+}
+
+// RecordHubReachable records whether the hub cluster is reachable, it is also tracked for the readiness probe
+func RecordHubReachable(reachable bool) {
+	trackHubReachable(reachable, time.Now())
+	if reachable {
+		hubReachable.Set(1)
+	} else {
+		hubReachable.Set(0)
+	}
+}
+
+// RecordStep records the result of the reconcile step
+func RecordStep(step string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	reconcileSteps.WithLabelValues(step, result).Inc()
+}
+
+// RecordAllowlistSize records the number of the entries in the metrics allowlist
+func RecordAllowlistSize(names, matches, renames, rules int) {
+	allowlistSize.WithLabelValues("names").Set(float64(names))
+	allowlistSize.WithLabelValues("matches").Set(float64(matches))
+	allowlistSize.WithLabelValues("renames").Set(float64(renames))
+	allowlistSize.WithLabelValues("rules").Set(float64(rules))
+}
+
+// RecordAllowlistSeries records the series counted for the allowlist entries and the entries dropped for the budget
+func RecordAllowlistSeries(series, dropped int) {
+	allowlistSeries.Set(float64(series))
+	allowlistDroppedEntries.Set(float64(dropped))
+}
+
+// RecordCollectorConfigEntries records the number of the matches, renames and recording rules rendered
+// into the metrics collector config
+func RecordCollectorConfigEntries(config string, matches, renames, rules int) {
+	collectorConfigEntries.WithLabelValues(config, "matches").Set(float64(matches))
+	collectorConfigEntries.WithLabelValues(config, "renames").Set(float64(renames))
+	collectorConfigEntries.WithLabelValues(config, "recording_rules").Set(float64(rules))
+}
+
+// DeleteCollectorConfigEntries removes the entries of the deleted metrics collector config
+func DeleteCollectorConfigEntries(config string) {
+	for _, t := range []string{"matches", "renames", "recording_rules"} {
+		collectorConfigEntries.DeleteLabelValues(config, t)
+	}
+}
+
+// RecordStatusSync records the time of the successful status sync to the hub cluster
+func RecordStatusSync(t time.Time) {
+	statusLastSync.Set(float64(t.Unix()))
+}
+
+func recordConditions(conditions []oav1beta1.StatusCondition) {
+	for _, t := range conditionTypes {
+		value := 0.0
+		if c := GetCondition(conditions, t); c != nil && c.Status == metav1.ConditionTrue {
+			value = 1
+		}
+		addonCondition.WithLabelValues(t).Set(value)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
+)
+
+func TestMetrics(t *testing.T) {
+	RecordHubReachable(false)
+	if v := testutil.ToFloat64(hubReachable); v != 0 {
+		t.Fatalf("Wrong hub_reachable, expected: 0, got: %v", v)
+	}
+	RecordHubReachable(true)
+	if v := testutil.ToFloat64(hubReachable); v != 1 {
+		t.Fatalf("Wrong hub_reachable, expected: 1, got: %v", v)
+	}
+
+	before := testutil.ToFloat64(reconcileSteps.WithLabelValues(StepHubFetch, "failure"))
+	RecordStep(StepHubFetch, fmt.Errorf("connection refused"))
+	RecordStep(StepHubFetch, nil)
+	if v := testutil.ToFloat64(reconcileSteps.WithLabelValues(StepHubFetch, "failure")); v != before+1 {
+		t.Fatalf("Wrong failure count, expected: %v, got: %v", before+1, v)
+	}
+
+	RecordAllowlistSize(3, 2, 1, 0)
+	if v := testutil.ToFloat64(allowlistSize.WithLabelValues("matches")); v != 2 {
+		t.Fatalf("Wrong allowlist size for matches, expected: 2, got: %v", v)
+	}
+
+	RecordCollectorConfigEntries("metrics-collector-config", 12, 1, 2)
+	if v := testutil.ToFloat64(collectorConfigEntries.WithLabelValues("metrics-collector-config", "matches")); v != 12 {
+		t.Fatalf("Wrong collector config matches, expected: 12, got: %v", v)
+	}
+	DeleteCollectorConfigEntries("metrics-collector-config")
+	if n := testutil.CollectAndCount(collectorConfigEntries); n != 0 {
+		t.Fatalf("Collector config entries not deleted, got %d series", n)
+	}
+
+	now := time.Now()
+	RecordStatusSync(now)
+	if v := testutil.ToFloat64(statusLastSync); v != float64(now.Unix()) {
+		t.Fatalf("Wrong last sync timestamp, expected: %v, got: %v", now.Unix(), v)
+	}
+
+	recordConditions([]oav1beta1.StatusCondition{
+		{Type: ConditionAvailable, Status: metav1.ConditionTrue},
+		{Type: ConditionDegraded, Status: metav1.ConditionFalse},
+	})
+	if v := testutil.ToFloat64(addonCondition.WithLabelValues(ConditionAvailable)); v != 1 {
+		t.Fatalf("Wrong Available condition, expected: 1, got: %v", v)
+	}
+	if v := testutil.ToFloat64(addonCondition.WithLabelValues(ConditionDegraded)); v != 0 {
+		t.Fatalf("Wrong Degraded condition, expected: 0, got: %v", v)
+	}
+}
@@ -4,6 +4,7 @@ package util
 
 import (
 	"context"
+	"reflect"
 	"time"
 
 	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
@@ -11,39 +12,276 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// condition types of the observabilityaddon
+const (
+	ConditionAvailable        = "Available"
+	ConditionProgressing      = "Progressing"
+	ConditionDegraded         = "Degraded"
+	ConditionDisabled         = "Disabled"
+	ConditionNotSupported     = "NotSupported"
+	ConditionInvalidAllowlist = "InvalidAllowlist"
+	// ConditionCardinalityBudgetExceeded is true if allowlist entries are dropped for the cardinality budget
+	ConditionCardinalityBudgetExceeded = "CardinalityBudgetExceeded"
+	// ConditionAlertForwardingProxyUnsupported is true if a proxy is required for the alert forwarding,
+	// which the additional alertmanager config of the cluster monitoring operator cannot apply
+	ConditionAlertForwardingProxyUnsupported = "AlertForwardingProxyUnsupported"
+)
+
+// the order of the conditions in the observabilityaddon status
+var conditionTypes = []string{
+	ConditionAvailable,
+	ConditionProgressing,
+	ConditionDegraded,
+	ConditionDisabled,
+	ConditionNotSupported,
+	ConditionInvalidAllowlist,
+	ConditionCardinalityBudgetExceeded,
+	ConditionAlertForwardingProxyUnsupported,
+}
+
+// conditionDef is the condition set when a status is reported
+type conditionDef struct {
+	status  metav1.ConditionStatus
+	reason  string
+	message string
+}
+
+type statusDef struct {
+	// condition is the condition type the status is about, the reason and details of the reported status
+	// are only applied to it
+	condition string
+	// the conditions set when the status is reported, the other conditions are not changed
+	conditions map[string]conditionDef
+}
+
+// the conditions shared by several statuses
+var (
+	notDegraded     = conditionDef{metav1.ConditionFalse, "NotDegraded", "Metrics collector is running as expected"}
+	metricsEnabled  = conditionDef{metav1.ConditionFalse, "MetricsEnabled", "enableMetrics is set to True"}
+	prometheusFound = conditionDef{metav1.ConditionFalse, "PrometheusFound", "Prometheus service found in this cluster"}
+)
+
 var (
-	conditions = map[string]map[string]string{
-		"Deployed": map[string]string{
-			"type":    "Progressing",
-			"reason":  "Deployed",
-			"message": "Metrics collector deployed"},
-		"Disabled": map[string]string{
-			"type":    "Disabled",
-			"reason":  "Disabled",
-			"message": "enableMetrics is set to False"},
-		"Degraded": map[string]string{
-			"type":    "Degraded",
-			"reason":  "Degraded",
-			"message": "Metrics collector deployment not successful"},
-		"NotSupported": map[string]string{
-			"type":    "NotSupported",
-			"reason":  "NotSupported",
-			"message": "No Prometheus service found in this cluster"},
+	statuses = map[string]statusDef{
+		// Deployed keeps the Deployed reason of the Progressing condition reported by the old versions,
+		// which the consumers in the hub cluster rely on, but the rollout is done so it is False
+		"Deployed": {
+			condition: ConditionProgressing,
+			conditions: map[string]conditionDef{
+				ConditionAvailable: {metav1.ConditionTrue, "MetricsCollectorAvailable",
+					"Metrics collector is available"},
+				ConditionProgressing:  {metav1.ConditionFalse, "Deployed", "Metrics collector deployed"},
+				ConditionDegraded:     notDegraded,
+				ConditionDisabled:     metricsEnabled,
+				ConditionNotSupported: prometheusFound,
+			},
+		},
+		"Progressing": {
+			condition: ConditionProgressing,
+			conditions: map[string]conditionDef{
+				ConditionProgressing:  {metav1.ConditionTrue, "Progressing", "Metrics collector is being deployed"},
+				ConditionDegraded:     notDegraded,
+				ConditionDisabled:     metricsEnabled,
+				ConditionNotSupported: prometheusFound,
+			},
+		},
+		"Disabled": {
+			condition: ConditionDisabled,
+			conditions: map[string]conditionDef{
+				ConditionAvailable:   {metav1.ConditionFalse, "MetricsDisabled", "Metrics collector is not deployed"},
+				ConditionProgressing: {metav1.ConditionFalse, "MetricsDisabled", "Metrics collector is not deployed"},
+				ConditionDegraded:    notDegraded,
+				ConditionDisabled:    {metav1.ConditionTrue, "Disabled", "enableMetrics is set to False"},
+			},
+		},
+		"Degraded": {
+			condition: ConditionDegraded,
+			conditions: map[string]conditionDef{
+				// the metrics collector deployed before may still be running
+				ConditionAvailable: {metav1.ConditionUnknown, "AvailabilityUnknown",
+					"The availability of the metrics collector is unknown while it is degraded"},
+				ConditionProgressing: {metav1.ConditionFalse, "DeploymentFailed",
+					"Metrics collector is not being deployed"},
+				ConditionDegraded:     {metav1.ConditionTrue, "Degraded", "Metrics collector deployment not successful"},
+				ConditionDisabled:     metricsEnabled,
+				ConditionNotSupported: prometheusFound,
+			},
+		},
+		"NotSupported": {
+			condition: ConditionNotSupported,
+			conditions: map[string]conditionDef{
+				ConditionAvailable:    {metav1.ConditionFalse, "PrometheusNotFound", "Metrics collector is not deployed"},
+				ConditionProgressing:  {metav1.ConditionFalse, "PrometheusNotFound", "Metrics collector is not deployed"},
+				ConditionDegraded:     notDegraded,
+				ConditionNotSupported: {metav1.ConditionTrue, "NotSupported", "No Prometheus service found in this cluster"},
+			},
+		},
+		// HubUnreachable is reported after the other statuses, the metrics collector cannot be known to push
+		// the metrics while the hub cluster is unreachable
+		"HubUnreachable": {
+			condition: ConditionAvailable,
+			conditions: map[string]conditionDef{
+				ConditionAvailable: {metav1.ConditionUnknown, "HubUnreachable",
+					"Hub cluster is unreachable, the last known observabilityaddon is used"},
+			},
+		},
+		"InvalidAllowlist": {
+			condition: ConditionInvalidAllowlist,
+			conditions: map[string]conditionDef{
+				ConditionInvalidAllowlist: {metav1.ConditionTrue, "InvalidAllowlist",
+					"Metrics allowlist is invalid, the last known good allowlist is kept"},
+			},
+		},
+		"ValidAllowlist": {
+			condition: ConditionInvalidAllowlist,
+			conditions: map[string]conditionDef{
+				ConditionInvalidAllowlist: {metav1.ConditionFalse, "ValidAllowlist", "Metrics allowlist is valid"},
+			},
+		},
+		"CardinalityBudgetExceeded": {
+			condition: ConditionCardinalityBudgetExceeded,
+			conditions: map[string]conditionDef{
+				ConditionCardinalityBudgetExceeded: {metav1.ConditionTrue, "CardinalityBudgetExceeded",
+					"Metrics series exceed the cardinality budget, the lowest-priority allowlist entries are dropped"},
+			},
+		},
+		"WithinCardinalityBudget": {
+			condition: ConditionCardinalityBudgetExceeded,
+			conditions: map[string]conditionDef{
+				ConditionCardinalityBudgetExceeded: {metav1.ConditionFalse, "WithinCardinalityBudget",
+					"Metrics series are within the cardinality budget"},
+			},
+		},
+		"CardinalityUnknown": {
+			condition: ConditionCardinalityBudgetExceeded,
+			conditions: map[string]conditionDef{
+				ConditionCardinalityBudgetExceeded: {metav1.ConditionUnknown, "SeriesCountFailed",
+					"Metrics series cannot be counted, the allowlist is not budgeted"},
+			},
+		},
+		"AlertForwardingProxyUnsupported": {
+			condition: ConditionAlertForwardingProxyUnsupported,
+			conditions: map[string]conditionDef{
+				ConditionAlertForwardingProxyUnsupported: {metav1.ConditionTrue, "ProxyNotSupported",
+					"The proxy of the alert forwarding is not applied, the additional alertmanager config of " +
+						"the cluster monitoring operator has no proxy setting"},
+			},
+		},
+		"AlertForwardingProxyNotRequired": {
+			condition: ConditionAlertForwardingProxyUnsupported,
+			conditions: map[string]conditionDef{
+				ConditionAlertForwardingProxyUnsupported: {metav1.ConditionFalse, "NoProxyRequired",
+					"No proxy is required for the alert forwarding"},
+			},
+		},
 	}
 )
 
+// Status is the status to be reported for the observabilityaddon
+type Status struct {
+	// Type is one of Deployed, Progressing, Disabled, Degraded, NotSupported, HubUnreachable, InvalidAllowlist,
+	// ValidAllowlist, CardinalityBudgetExceeded, WithinCardinalityBudget, CardinalityUnknown,
+	// AlertForwardingProxyUnsupported and AlertForwardingProxyNotRequired
+	Type string
+	// Reason overrides the default reason of the condition the status is about if it is not empty
+	Reason string
+	// Details is appended to the default message of the condition the status is about,
+	// it usually carries the underlying error
+	Details string
+}
+
+// ReportStatus reports the status without details
 func ReportStatus(ctx context.Context, client client.Client, i *oav1beta1.ObservabilityAddon, t string) {
-	i.Status.Conditions = []oav1beta1.StatusCondition{
-		{
-			Type:               conditions[t]["type"],
-			Status:             metav1.ConditionTrue,
-			LastTransitionTime: metav1.NewTime(time.Now()),
-			Reason:             conditions[t]["reason"],
-			Message:            conditions[t]["message"],
-		},
+	ReportStatuses(ctx, client, i, Status{Type: t})
+}
+
+// ReportStatusWithMessage reports the status with the details appended to the default message
+func ReportStatusWithMessage(ctx context.Context, client client.Client, i *oav1beta1.ObservabilityAddon,
+	t string, details string) {
+	ReportStatuses(ctx, client, i, Status{Type: t, Details: details})
+}
+
+// ReportStatuses sets the conditions for all the statuses,
+// and updates the observabilityaddon only if any condition is changed
+func ReportStatuses(ctx context.Context, client client.Client, i *oav1beta1.ObservabilityAddon, s ...Status) {
+	conditions := make([]oav1beta1.StatusCondition, len(i.Status.Conditions))
+	copy(conditions, i.Status.Conditions)
+	for _, status := range s {
+		conditions = setConditions(conditions, status, time.Now())
+	}
+	recordConditions(conditions)
+	if reflect.DeepEqual(conditions, i.Status.Conditions) {
+		return
 	}
+	i.Status.Conditions = conditions
 	err := client.Status().Update(ctx, i)
 	if err != nil {
 		log.Error(err, "Failed to update status for observabilityaddon")
 	}
 }
+
+// setConditions sets the conditions for the status, the LastTransitionTime is changed only when
+// the status of the condition is changed. The conditions are returned in the fixed order.
+func setConditions(conditions []oav1beta1.StatusCondition, s Status, now time.Time) []oav1beta1.StatusCondition {
+	def, ok := statuses[s.Type]
+	if !ok {
+		log.Info("Unknown status type", "type", s.Type)
+		return conditions
+	}
+	current := map[string]oav1beta1.StatusCondition{}
+	others := []oav1beta1.StatusCondition{}
+	for _, c := range conditions {
+		if !isKnownConditionType(c.Type) {
+			// keep the conditions with unknown types, e.g. the ones set by the old versions
+			others = append(others, c)
+		} else if _, ok := current[c.Type]; !ok {
+			current[c.Type] = c
+		}
+	}
+	for t, cd := range def.conditions {
+		if t == def.condition {
+			if s.Reason != "" {
+				cd.reason = s.Reason
+			}
+			if s.Details != "" {
+				cd.message = cd.message + ": " + s.Details
+			}
+		}
+		c, ok := current[t]
+		if !ok || c.Status != cd.status {
+			c.LastTransitionTime = metav1.NewTime(now)
+		}
+		c.Type = t
+		c.Status = cd.status
+		c.Reason = cd.reason
+		c.Message = cd.message
+		current[t] = c
+	}
+
+	result := []oav1beta1.StatusCondition{}
+	for _, t := range conditionTypes {
+		if c, ok := current[t]; ok {
+			result = append(result, c)
+		}
+	}
+	return append(result, others...)
+}
+
+func isKnownConditionType(t string) bool {
+	for _, v := range conditionTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCondition returns the condition with the type, or nil if it does not exist
+func GetCondition(conditions []oav1beta1.StatusCondition, t string) *oav1beta1.StatusCondition {
+	for i := range conditions {
+		if conditions[i].Type == t {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
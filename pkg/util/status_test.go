@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	oav1beta1 "github.com/stolostron/multicluster-observability-operator/api/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -45,7 +46,7 @@ func TestReportStatus(t *testing.T) {
 		},
 		{
 			Type:    "Progressing",
-			Status:  metav1.ConditionTrue,
+			Status:  metav1.ConditionFalse,
 			Reason:  "Deployed",
 			Message: "Metrics collector deployed",
 		},
@@ -62,9 +63,109 @@ func TestReportStatus(t *testing.T) {
 	c := fake.NewFakeClient(objs...)
 	for i := range statusList {
 		ReportStatus(context.TODO(), c, oa, statusList[i])
-		if oa.Status.Conditions[0].Message != expectedStatus[i].Message || oa.Status.Conditions[0].Reason != expectedStatus[i].Reason || oa.Status.Conditions[0].Status != expectedStatus[i].Status || oa.Status.Conditions[0].Type != expectedStatus[i].Type {
-			t.Errorf("Error: Status not updated. Expected: %s, Actual: %s", expectedStatus[i], fmt.Sprintf("%+v\n", oa.Status.Conditions[0]))
+		found := GetCondition(oa.Status.Conditions, expectedStatus[i].Type)
+		if found == nil || found.Message != expectedStatus[i].Message || found.Reason != expectedStatus[i].Reason || found.Status != expectedStatus[i].Status {
+			t.Errorf("Error: Status not updated. Expected: %s, Actual: %s", expectedStatus[i], fmt.Sprintf("%+v\n", oa.Status.Conditions))
+		}
+		for _, c := range oa.Status.Conditions {
+			if c.Type != expectedStatus[i].Type && c.Type != ConditionAvailable && c.Status == metav1.ConditionTrue {
+				t.Errorf("Error: Condition %s should not be True after %s reported", c.Type, statusList[i])
+			}
+		}
+	}
+
+	ReportStatusWithMessage(context.TODO(), c, oa, "InvalidAllowlist", "invalid metric name")
+	found := GetCondition(oa.Status.Conditions, "InvalidAllowlist")
+	if found == nil || found.Status != metav1.ConditionTrue ||
+		found.Message != "Metrics allowlist is invalid, the last known good allowlist is kept: invalid metric name" {
+		t.Errorf("Error: Status not updated with message. Actual: %s", fmt.Sprintf("%+v\n", oa.Status.Conditions))
+	}
+	if GetCondition(oa.Status.Conditions, "Disabled").Status != metav1.ConditionTrue {
+		t.Errorf("Error: Disabled condition should not be changed by InvalidAllowlist")
+	}
+
+	ReportStatus(context.TODO(), c, oa, "AlertForwardingProxyUnsupported")
+	found = GetCondition(oa.Status.Conditions, ConditionAlertForwardingProxyUnsupported)
+	if found == nil || found.Status != metav1.ConditionTrue || found.Reason != "ProxyNotSupported" {
+		t.Errorf("Error: Unsupported proxy not reported. Actual: %s", fmt.Sprintf("%+v\n", oa.Status.Conditions))
+	}
+	ReportStatus(context.TODO(), c, oa, "AlertForwardingProxyNotRequired")
+	found = GetCondition(oa.Status.Conditions, ConditionAlertForwardingProxyUnsupported)
+	if found == nil || found.Status != metav1.ConditionFalse {
+		t.Errorf("Error: Unsupported proxy not cleared. Actual: %s", fmt.Sprintf("%+v\n", oa.Status.Conditions))
+	}
+}
+
+func TestSetConditions(t *testing.T) {
+	t0 := time.Now().Add(-time.Hour)
+	t1 := time.Now()
+	conditions := setConditions(nil, Status{Type: "Deployed"}, t0)
+	if len(conditions) != 5 || conditions[0].Type != ConditionAvailable {
+		t.Fatalf("Wrong conditions: %v", conditions)
+	}
+
+	// no transition if the status is not changed
+	conditions = setConditions(conditions, Status{Type: "Deployed", Details: "again"}, t1)
+	progressing := GetCondition(conditions, ConditionProgressing)
+	if !progressing.LastTransitionTime.Time.Equal(metav1.NewTime(t0).Time) {
+		t.Fatalf("LastTransitionTime should not be changed: %v", progressing)
+	}
+	if progressing.Message != "Metrics collector deployed: again" || progressing.Reason != "Deployed" {
+		t.Fatalf("Message not updated: %v", progressing)
+	}
+	if progressing.Status != metav1.ConditionFalse {
+		t.Fatalf("Progressing condition should be False once deployed: %v", progressing)
+	}
+	// the details are only applied to the condition the status is about
+	available := GetCondition(conditions, ConditionAvailable)
+	if available.Status != metav1.ConditionTrue || available.Reason != "MetricsCollectorAvailable" ||
+		available.Message != "Metrics collector is available" {
+		t.Fatalf("Wrong available condition: %v", available)
+	}
+
+	// transition if the status is flipped
+	conditions = setConditions(conditions, Status{Type: "Degraded", Reason: "HubSecretMissing", Details: "secret not found"}, t1)
+	degraded := GetCondition(conditions, ConditionDegraded)
+	if degraded.Status != metav1.ConditionTrue || degraded.Reason != "HubSecretMissing" ||
+		!degraded.LastTransitionTime.Time.Equal(metav1.NewTime(t1).Time) {
+		t.Fatalf("Wrong degraded condition: %v", degraded)
+	}
+	notSupported := GetCondition(conditions, ConditionNotSupported)
+	if !notSupported.LastTransitionTime.Time.Equal(metav1.NewTime(t0).Time) {
+		t.Fatalf("LastTransitionTime should not be changed for NotSupported: %v", notSupported)
+	}
+	if notSupported.Reason != "PrometheusFound" {
+		t.Fatalf("The reason of Degraded is set for NotSupported: %v", notSupported)
+	}
+	if available := GetCondition(conditions, ConditionAvailable); available.Status != metav1.ConditionUnknown {
+		t.Fatalf("Available condition should be Unknown while degraded: %v", available)
+	}
+
+	// the Available condition is Unknown while the hub is unreachable
+	conditions = setConditions(conditions, Status{Type: "Deployed"}, t1)
+	conditions = setConditions(conditions, Status{Type: "HubUnreachable", Details: "connection refused"}, t1)
+	available = GetCondition(conditions, ConditionAvailable)
+	if available.Status != metav1.ConditionUnknown || available.Reason != "HubUnreachable" {
+		t.Fatalf("Wrong available condition while the hub is unreachable: %v", available)
+	}
+	if progressing := GetCondition(conditions, ConditionProgressing); progressing.Reason != "Deployed" {
+		t.Fatalf("Progressing condition changed while the hub is unreachable: %v", progressing)
+	}
+
+	// Degraded clears the Disabled and NotSupported conditions set before
+	conditions = setConditions(conditions, Status{Type: "Disabled"}, t1)
+	conditions = setConditions(conditions, Status{Type: "NotSupported"}, t1)
+	conditions = setConditions(conditions, Status{Type: "Degraded"}, t1)
+	for _, ct := range []string{ConditionDisabled, ConditionNotSupported} {
+		if c := GetCondition(conditions, ct); c.Status != metav1.ConditionFalse {
+			t.Fatalf("%s condition should be False while degraded: %v", ct, c)
 		}
 	}
 
+	// conditions with unknown types are kept
+	conditions = append(conditions, oav1beta1.StatusCondition{Type: "Deployed", Status: metav1.ConditionTrue})
+	conditions = setConditions(conditions, Status{Type: "Deployed"}, t1)
+	if GetCondition(conditions, "Deployed") == nil {
+		t.Fatalf("Condition with unknown type removed: %v", conditions)
+	}
 }